@@ -2,17 +2,17 @@ package main
 
 import (
 	"io/ioutil"
+	"os"
 
 	"gopkg.in/yaml.v2"
 
+	"github.com/chiepomme/chienote/convert"
 	"github.com/pkg/errors"
 )
 
 const cacheRoot = "_cache/"
 const noteCacheDirName = "notes/"
 const resourceCacheDirName = "resources/"
-const postDirName = "_posts/"
-const resourceDirName = "resources/"
 
 const configFilePath = "_evernote.yml"
 
@@ -22,17 +22,18 @@ type config struct {
 	DeveloperToken string `yaml:"developer_token"`
 	Sandbox        bool   `yaml:"is_sandbox"`
 	NotebookName   string `yaml:"notebook_name"`
+
+	Convert convert.Options `yaml:",inline"`
 }
 
 func getConfig() (*config, error) {
-	var cfg *config
-
 	configBytes, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't read %v", configFilePath)
 	}
-	if err := yaml.Unmarshal(configBytes, &cfg); err != nil {
-		return nil, errors.Wrapf(err, "can't unmarshal %v", configFilePath)
+	cfg, err := unmarshalConfig(configBytes)
+	if err != nil {
+		return nil, err
 	}
 	if cfg.ClientKey == "" {
 		return nil, errors.Errorf("client key is blank %v", configFilePath)
@@ -49,3 +50,28 @@ func getConfig() (*config, error) {
 
 	return cfg, nil
 }
+
+// getConvertOptions reads only convert options, which don't need evernote credentials.
+// They are the default ones if there is no configuration file.
+func getConvertOptions() (*convert.Options, error) {
+	configBytes, err := ioutil.ReadFile(configFilePath)
+	if os.IsNotExist(err) {
+		return &convert.Options{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read %v", configFilePath)
+	}
+	cfg, err := unmarshalConfig(configBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg.Convert, nil
+}
+
+func unmarshalConfig(configBytes []byte) (*config, error) {
+	cfg := &config{}
+	if err := yaml.Unmarshal(configBytes, cfg); err != nil {
+		return nil, errors.Wrapf(err, "can't unmarshal %v", configFilePath)
+	}
+	return cfg, nil
+}
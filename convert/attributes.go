@@ -0,0 +1,92 @@
+package convert
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/dreampuf/evernote-sdk-golang/types"
+	"github.com/pkg/errors"
+)
+
+// noteAttributes are attributes of notes which can be written as front matter.
+// They return nil if the attribute isn't set, and timestamps are converted to dates later.
+var noteAttributes = map[string]func(attrs *types.NoteAttributes) interface{}{
+	"author":             func(attrs *types.NoteAttributes) interface{} { return stringValue(attrs.Author) },
+	"source":             func(attrs *types.NoteAttributes) interface{} { return stringValue(attrs.Source) },
+	"source_url":         func(attrs *types.NoteAttributes) interface{} { return stringValue(attrs.SourceURL) },
+	"source_application": func(attrs *types.NoteAttributes) interface{} { return stringValue(attrs.SourceApplication) },
+	"place_name":         func(attrs *types.NoteAttributes) interface{} { return stringValue(attrs.PlaceName) },
+	"content_class":      func(attrs *types.NoteAttributes) interface{} { return stringValue(attrs.ContentClass) },
+	"last_edited_by":     func(attrs *types.NoteAttributes) interface{} { return stringValue(attrs.LastEditedBy) },
+	"subject_date":       func(attrs *types.NoteAttributes) interface{} { return timestampValue(attrs.SubjectDate) },
+	"share_date":         func(attrs *types.NoteAttributes) interface{} { return timestampValue(attrs.ShareDate) },
+	"reminder_time":      func(attrs *types.NoteAttributes) interface{} { return timestampValue(attrs.ReminderTime) },
+	"reminder_done_time": func(attrs *types.NoteAttributes) interface{} { return timestampValue(attrs.ReminderDoneTime) },
+	"location": func(attrs *types.NoteAttributes) interface{} {
+		if attrs.Latitude == nil || attrs.Longitude == nil {
+			return nil
+		}
+		location := yaml.MapSlice{{Key: "latitude", Value: *attrs.Latitude}, {Key: "longitude", Value: *attrs.Longitude}}
+		if attrs.Altitude != nil {
+			location = append(location, yaml.MapItem{Key: "altitude", Value: *attrs.Altitude})
+		}
+		return location
+	},
+}
+
+func stringValue(s *string) interface{} {
+	if s == nil || *s == "" {
+		return nil
+	}
+	return *s
+}
+
+func timestampValue(ts *types.Timestamp) interface{} {
+	if ts == nil || *ts == 0 {
+		return nil
+	}
+	return *ts
+}
+
+// timestampTime converts an evernote timestamp in milliseconds to time in loc
+func timestampTime(ts types.Timestamp, loc *time.Location) time.Time {
+	return time.Unix(int64(ts)/1000, 0).In(loc)
+}
+
+func validateAttributes(attributes map[string]string) error {
+	for name := range attributes {
+		if _, ok := noteAttributes[name]; !ok {
+			names := make([]string, 0, len(noteAttributes))
+			for name := range noteAttributes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return errors.Errorf("unknown note attribute %v (available: %v)", name, names)
+		}
+	}
+	return nil
+}
+
+// attributeFields returns front matter fields of attributes which are set in the note.
+// attributes maps names of attributes to front matter keys.
+func attributeFields(attrs *types.NoteAttributes, attributes map[string]string, loc *time.Location) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if attrs == nil {
+		return fields
+	}
+	for name, key := range attributes {
+		if key == "" {
+			continue
+		}
+		value := noteAttributes[name](attrs)
+		if ts, ok := value.(types.Timestamp); ok {
+			value = timestampTime(ts, loc)
+		}
+		if value != nil {
+			fields[key] = value
+		}
+	}
+	return fields
+}
@@ -0,0 +1,207 @@
+package convert
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// codeFenceOpen is an opening line of a code fence with an optional language
+var codeFenceOpen = regexp.MustCompile("^```\\s*([^`\\s]*)\\s*$")
+
+// transformCodeFences replaces lines between ``` lines with code blocks.
+// Fences must be lines by themselves, and the lines between them are kept as they are
+// including indentation and blank lines.
+func transformCodeFences(doc *goquery.Document, ctx *TransformContext) error {
+	s := fenceScanner{title: ctx.Post.Title}
+	for _, note := range doc.Find("en-note").Nodes {
+		if err := s.scan(note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fenceScanner finds code fences in lines of a note
+type fenceScanner struct {
+	title string
+	// line is the number of lines scanned so far, for error messages
+	line int
+}
+
+// scan replaces code fences in children of parent, descending into containers of lines
+// like Evernote's code blocks
+func (s *fenceScanner) scan(parent *html.Node) error {
+	for c := parent.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if isBlock(c) && hasBlockChild(c) {
+			if err := s.scan(c); err != nil {
+				return err
+			}
+			// Evernote's code block which only has a fence is the code block itself
+			if pre := onlyElementChild(c); isCodeBlockStyle(c) && pre != nil && pre.Data == "pre" {
+				c.RemoveChild(pre)
+				parent.InsertBefore(pre, c)
+				parent.RemoveChild(c)
+				c = pre
+			}
+			continue
+		}
+
+		lines := codeLines(c)
+		match := codeFenceOpen.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if (c.Data != "div" && c.Data != "p") || match == nil {
+			s.line += len(lines)
+			continue
+		}
+		pre, err := s.replaceFence(c, match[1], lines[1:])
+		if err != nil {
+			return err
+		}
+		c = pre
+	}
+	return nil
+}
+
+// isCodeBlockStyle reports whether n is a code block of Evernote's editor, which has -en-codeblock in its style
+func isCodeBlockStyle(n *html.Node) bool {
+	return n.Data == "div" && strings.Contains(strings.Replace(attr(n, "style"), " ", "", -1), "-en-codeblock:true")
+}
+
+// replaceFence replaces nodes from open to the closing fence with a code block, and returns the last inserted node.
+// rest are lines of open after the opening fence.
+func (s *fenceScanner) replaceFence(open *html.Node, language string, rest []string) (*html.Node, error) {
+	s.line++
+	openLine := s.line
+
+	var code []string
+	node, lines := open, rest
+	for {
+		for i, line := range lines {
+			s.line++
+			if strings.TrimSpace(line) != "```" {
+				code = append(code, line)
+				continue
+			}
+
+			parent := node.Parent
+			pre := newCodeBlock(language, code)
+			parent.InsertBefore(pre, open)
+
+			for n := open; ; {
+				next := n.NextSibling
+				parent.RemoveChild(n)
+				if n == node {
+					break
+				}
+				n = next
+			}
+
+			// lines after the closing fence in the same element are kept as a line of text
+			last := pre
+			if after := lines[i+1:]; len(after) > 0 {
+				last = newNode("div")
+				for j, line := range after {
+					if j > 0 {
+						last.AppendChild(newNode("br"))
+					}
+					last.AppendChild(&html.Node{Type: html.TextNode, Data: line})
+				}
+				parent.InsertBefore(last, pre.NextSibling)
+			}
+			return last, nil
+		}
+
+		node = nextLine(node)
+		if node == nil {
+			return nil, errors.Errorf("code fence at line %d of note %q isn't closed", openLine, s.title)
+		}
+		lines = codeLines(node)
+	}
+}
+
+// nextLine returns the next sibling of n which has text, skipping whitespace between elements
+func nextLine(n *html.Node) *html.Node {
+	for n = n.NextSibling; n != nil; n = n.NextSibling {
+		switch {
+		case n.Type == html.ElementNode:
+			return n
+		case n.Type == html.TextNode && strings.TrimSpace(n.Data) != "":
+			return n
+		}
+	}
+	return nil
+}
+
+// codeLines returns lines of n as they are written in the note.
+// Non-breaking spaces, which Evernote uses for indentation, are turned into spaces.
+func codeLines(n *html.Node) []string {
+	if isBlock(n) && hasBlockChild(n) {
+		var lines []string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode || (c.Type == html.TextNode && strings.TrimSpace(c.Data) != "") {
+				lines = append(lines, codeLines(c)...)
+			}
+		}
+		return lines
+	}
+
+	var text strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			// newlines in ENML are only formatting of the source
+			text.WriteString(strings.NewReplacer("\u00a0", " ", "\r", "", "\n", "").Replace(n.Data))
+		case n.Type == html.ElementNode && n.Data == "br":
+			text.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	// a line ends with <br> when it is blank or the last one of the element
+	return strings.Split(strings.TrimSuffix(text.String(), "\n"), "\n")
+}
+
+// codeBlockLanguage is the first line of Evernote's code block which tells its language, like "lang: go"
+var codeBlockLanguage = regexp.MustCompile(`^lang(?:uage)?:\s*(\S+)$`)
+
+// transformCodeBlocks replaces Evernote's code blocks with code blocks.
+// The language is given by the first line like "lang: go".
+func transformCodeBlocks(doc *goquery.Document, ctx *TransformContext) error {
+	for _, n := range doc.Find("en-note div").Nodes {
+		// code blocks which have fences are converted by transformCodeFences
+		if n.Parent == nil || !isCodeBlockStyle(n) || goquery.NewDocumentFromNode(n).Find("pre").Length() > 0 {
+			continue
+		}
+
+		lines := codeLines(n)
+		language := ""
+		if match := codeBlockLanguage.FindStringSubmatch(strings.TrimSpace(lines[0])); match != nil {
+			language = match[1]
+			lines = lines[1:]
+		}
+
+		pre := newCodeBlock(language, lines)
+		n.Parent.InsertBefore(pre, n)
+		n.Parent.RemoveChild(n)
+	}
+	return nil
+}
+
+// newCodeBlock returns <pre data-lang="..."> which is rendered as a code block for each format
+func newCodeBlock(language string, lines []string) *html.Node {
+	pre := newNode("pre")
+	pre.Attr = []html.Attribute{{Key: "data-lang", Val: language}}
+	code := newNode("code")
+	code.AppendChild(&html.Node{Type: html.TextNode, Data: strings.Join(lines, "\n")})
+	pre.AppendChild(code)
+	return pre
+}
@@ -1,9 +1,7 @@
 package convert
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -15,29 +13,41 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/dreampuf/evernote-sdk-golang/types"
 	"github.com/pkg/errors"
-	"github.com/yosssi/gohtml"
 )
 
-type frontMatter struct {
-	Title     string   `yaml:"title,omitempty"`
-	Layout    string   `yaml:"layout,omitempty"`
-	Published bool     `yaml:"published"`
-	Date      string   `yaml:"date,omitempty"`
-	Tags      []string `yaml:"tags,omitempty"`
-}
-
 const cacheExtension = ".yml"
 
-// Convert local cache to static files
-func Convert(cacheRoot string, noteCacheDirName string, resourceCacheDirName string, jekyllRoot string, postsDirName string, resourcesDirName string, cleanNeeded bool) error {
-	jekyllPostsDir := path.Join(jekyllRoot, postsDirName)
-	jekyllResourcesDir := path.Join(jekyllRoot, resourcesDirName)
+// Convert local cache to static files for the target selected in opts
+// Files generated by the previous run are recorded in the manifest under cacheRoot,
+// and only those files are overwritten or removed. Other files in siteRoot are left alone.
+// Only resources referenced by published notes are copied.
+// It fails before writing anything if posts of two notes have the same path.
+// notebookName is the name of the synced notebook, which can be used in paths of posts.
+// If dryRun is true, it only prints which files would be created, overwritten or removed.
+func Convert(cacheRoot string, noteCacheDirName string, resourceCacheDirName string, siteRoot string, notebookName string, opts Options, dryRun bool) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	optsHash, err := opts.hash()
+	if err != nil {
+		return err
+	}
+	target, err := newTarget(&opts)
+	if err != nil {
+		return err
+	}
+	pipeline, err := newTransformers(opts.Transforms)
+	if err != nil {
+		return err
+	}
+
 	noteCacheDir := path.Join(cacheRoot, noteCacheDirName)
 	resourceCacheDir := path.Join(cacheRoot, resourceCacheDirName)
+	manifestPath := path.Join(cacheRoot, manifestFileName)
 
 	notefiles, err := ioutil.ReadDir(noteCacheDir)
 	if err != nil {
-		return errors.Wrapf(err, "can't get cached notes", noteCacheDir)
+		return errors.Wrapf(err, "can't get cached notes %v", noteCacheDir)
 	}
 
 	resourceFiles, err := ioutil.ReadDir(resourceCacheDir)
@@ -45,205 +55,396 @@ func Convert(cacheRoot string, noteCacheDirName string, resourceCacheDirName str
 		return errors.Wrapf(err, "can't get cached resources %v", resourceCacheDir)
 	}
 
-	createDestinations(cleanNeeded, &jekyllPostsDir, &jekyllResourcesDir)
+	previousManifest, err := loadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	w := newFileWriter(dryRun, previousManifest, hashConfig(siteRoot, notebookName, optsHash))
 
+	if err := convertNotes(w, target, pipeline, &opts, notebookName, notefiles, resourceFiles, noteCacheDir, resourceCacheDir, siteRoot); err != nil {
+		// files written so far must stay owned, or the next run refuses to overwrite them
+		w.keepPrevious()
+		if saveErr := w.saveManifest(manifestPath); saveErr != nil {
+			fmt.Printf("%+v\n", saveErr)
+		}
+		return err
+	}
+
+	if err := w.removeStale(); err != nil {
+		return err
+	}
+
+	return w.saveManifest(manifestPath)
+}
+
+// cachedNote is a note in the cache and the post made from it
+type cachedNote struct {
+	guid string
+	path string
+	note *types.Note
+	doc  *goquery.Document
+	usn  int32
+	post *Post
+}
+
+// loadCachedNotes loads notes to be written. Unpublished notes are left out if drafts are skipped.
+func loadCachedNotes(opts *Options, notebookName string, notefiles []os.FileInfo, noteCacheDir string) ([]*cachedNote, error) {
+	notes := make([]*cachedNote, 0, len(notefiles))
 	for _, notefile := range notefiles {
-		cachedNote := &types.Note{}
+		note := &types.Note{}
 		cachedNotePath := path.Join(noteCacheDir, notefile.Name())
 		yamlBytes, err := ioutil.ReadFile(cachedNotePath)
 		if err != nil {
-			return errors.Wrapf(err, "can't read cached note file %v", cachedNotePath)
+			return nil, errors.Wrapf(err, "can't read cached note file %v", cachedNotePath)
 		}
-		if err := yaml.Unmarshal(yamlBytes, cachedNote); err != nil {
-			return errors.Wrapf(err, "can't unmarshal cached note file %v", cachedNotePath)
+		if err := yaml.Unmarshal(yamlBytes, note); err != nil {
+			return nil, errors.Wrapf(err, "can't unmarshal cached note file %v", cachedNotePath)
 		}
 
-		created := time.Unix(int64(*cachedNote.Created)/1000, 0)
-		created = created.In(time.Local)
+		guid := strings.TrimSuffix(notefile.Name(), cacheExtension)
+		var usn int32
+		if note.UpdateSequenceNum != nil {
+			usn = *note.UpdateSequenceNum
+		}
 
-		html, err := replaceEvernoteTags(cachedNote.Content, &resourceFiles, &resourcesDirName)
+		doc, err := parseENML(*note.Content)
 		if err != nil {
-			return errors.Wrapf(err, "can't replace evernote tags %v", cachedNotePath)
+			return nil, errors.Wrapf(err, "can't parse note %q", *note.Title)
 		}
-		*html = strings.Replace(*html, "\u00a0", " ", -1)
-		*html = gohtml.Format(*html)
-
-		fm := frontMatter{
-			Title:     *cachedNote.Title,
-			Layout:    "post",
-			Published: false,
-			Date:      created.Format("2006-01-02 15:04:05 -0700"),
-			Tags:      cachedNote.TagNames,
+		fields, err := extractFrontMatter(doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read front matter of note %q", *note.Title)
 		}
-
-		for i, tag := range fm.Tags {
-			if tag == "published" {
-				fm.Published = true
-				fm.Tags = append(fm.Tags[:i], fm.Tags[i+1:]...)
-				break
-			}
+		post, err := newPost(opts, notebookName, note, guid, fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read front matter of note %q", *note.Title)
+		}
+		if opts.Drafts == draftsSkip && !post.Published {
+			continue
 		}
 
-		for i, tag := range fm.Tags {
-			if tag == "page" {
-				fm.Layout = "page"
-				fm.Tags = append(fm.Tags[:i], fm.Tags[i+1:]...)
-				break
-			}
+		notes = append(notes, &cachedNote{
+			guid: guid,
+			path: cachedNotePath,
+			note: note,
+			doc:  doc,
+			usn:  usn,
+			post: post,
+		})
+	}
+	return notes, nil
+}
+
+// newPost makes a post from the note, with fields of front matter in the note applied
+func newPost(opts *Options, notebookName string, note *types.Note, guid string, fields yaml.MapSlice) (*Post, error) {
+	loc := opts.location
+	created := timestampTime(*note.Created, loc)
+	updated := created
+	if note.Updated != nil {
+		updated = timestampTime(*note.Updated, loc)
+	}
+
+	date := created
+	switch opts.DateFrom {
+	case "", dateFromSubject:
+		// the subject date is the date the note is about, e.g. when a photo was taken
+		if note.Attributes != nil && note.Attributes.SubjectDate != nil && *note.Attributes.SubjectDate != 0 {
+			date = timestampTime(*note.Attributes.SubjectDate, loc)
 		}
+	case dateFromUpdated:
+		date = updated
+	}
 
-		fmyaml, err := yaml.Marshal(fm)
-		if err != nil {
-			return errors.Wrap(err, "can't create front matter")
+	post := &Post{
+		GUID:      guid,
+		Title:     *note.Title,
+		Notebook:  opts.Slug.sanitize(notebookName),
+		Date:      date,
+		Updated:   updated,
+		Published: false,
+		Extension: ".html",
+	}
+	if opts.Format == formatMarkdown {
+		post.Extension = ".md"
+	}
+	if len(opts.Attributes) > 0 {
+		post.Fields = attributeFields(note.Attributes, opts.Attributes, loc)
+	}
+	if note.Attributes != nil {
+		post.SourceURL, _ = stringValue(note.Attributes.SourceURL).(string)
+	}
+	if opts.WebClips.isWebClip(note.Attributes) {
+		post.WebClip = true
+		if post.Fields == nil {
+			post.Fields = map[string]interface{}{}
 		}
+		post.Fields[opts.WebClips.field()] = true
+	}
 
-		*html = "---\n" + string(fmyaml) + "---\n" + *html
+	var publishAt time.Time
+	if opts.ScheduleByReminder && note.Attributes != nil && note.Attributes.ReminderTime != nil && *note.Attributes.ReminderTime != 0 {
+		publishAt = timestampTime(*note.Attributes.ReminderTime, loc)
+	}
 
-		var noteFileName string
-		if cachedNote.Attributes.SourceURL != nil && *cachedNote.Attributes.SourceURL != "" {
-			noteFileName = *cachedNote.Attributes.SourceURL
-		} else {
-			// TODO: need to sanitize title
-			noteFileName = *cachedNote.Title
+	tags := note.TagNames
+	var slug string
+	for _, item := range fields {
+		key := fmt.Sprint(item.Key)
+		switch key {
+		case "title", "slug":
+			value, ok := item.Value.(string)
+			if !ok {
+				return nil, errors.Errorf("%v must be a string", key)
+			}
+			if key == "title" {
+				post.Title = value
+			} else {
+				slug = value
+			}
+		case "date":
+			date, err := parseFrontMatterDate(item.Value, loc)
+			if err != nil {
+				return nil, err
+			}
+			post.Date = date
+		case "publish_at":
+			date, err := parseFrontMatterDate(item.Value, loc)
+			if err != nil {
+				return nil, err
+			}
+			publishAt = date
+		case "tags":
+			values, ok := item.Value.([]interface{})
+			if !ok {
+				return nil, errors.Errorf("tags must be a list")
+			}
+			for _, value := range values {
+				tags = append(tags, fmt.Sprint(value))
+			}
 		}
+	}
 
-		var notePath string
-		if fm.Layout == "page" {
-			notePath = path.Join(jekyllRoot, noteFileName+".html")
-		} else {
-			notePath = path.Join(jekyllPostsDir, created.Format("2006-01-02")+"-"+noteFileName+".html")
+	opts.Tags.apply(post, tags)
+
+	// published and page are applied after tags so that they can override tags
+	for _, item := range fields {
+		key := fmt.Sprint(item.Key)
+		switch key {
+		case "title", "slug", "date", "publish_at", "tags":
+		case "published", "page":
+			value, ok := item.Value.(bool)
+			if !ok {
+				return nil, errors.Errorf("%v must be true or false", key)
+			}
+			if key == "published" {
+				post.Published = value
+			} else {
+				post.Page = value
+			}
+		default:
+			if post.Fields == nil {
+				post.Fields = map[string]interface{}{}
+			}
+			post.Fields[key] = item.Value
 		}
+	}
 
-		if err := ioutil.WriteFile(notePath, []byte(*html), os.ModePerm); err != nil {
-			return errors.Wrapf(err, "can't create note file %v", notePath)
+	// scheduled posts are dated by the publish date, and published by convert after the date
+	if !publishAt.IsZero() {
+		post.Date = publishAt
+		if publishAt.After(time.Now()) {
+			post.Published = false
 		}
+	}
+	post.Draft = !post.Published && !post.Page && (opts.Drafts == "" || opts.Drafts == draftsDir)
 
-		for _, resourceFile := range resourceFiles {
-			copyResourceFile(resourceCacheDir, jekyllResourcesDir, resourceFile.Name())
+	if slug != "" {
+		post.Slug = opts.Slug.sanitize(slug)
+		if post.Slug == "" {
+			return nil, errors.Errorf("slug %q has no letters", slug)
 		}
+		return post, nil
 	}
 
-	return nil
-}
-
-func createDestinations(needClean bool, jekyllPostsDir *string, jekyllResourcesDir *string) {
-	if needClean {
-		os.RemoveAll(*jekyllPostsDir)
-		os.RemoveAll(*jekyllResourcesDir)
+	var sourceURL string
+	if note.Attributes != nil && note.Attributes.SourceURL != nil {
+		sourceURL = *note.Attributes.SourceURL
 	}
+	post.Slug = opts.Slug.slug(post.Title, sourceURL, guid, post)
 
-	os.MkdirAll(*jekyllPostsDir, os.ModePerm)
-	os.MkdirAll(*jekyllResourcesDir, os.ModePerm)
+	return post, nil
 }
 
-func replaceEvernoteTags(enml *string, resourceFiles *[]os.FileInfo, jekyllResourcesDirName *string) (*string, error) {
-	// FIXME
-	// standard library's html parser can't handle unknown self closing tags
-	// https://github.com/golang/net/blob/master/html/parse.go#L727-L980
-	// so replace en-medias to imgs to parse them
-	*enml = strings.Replace(*enml, "<en-media", `<img en-media="true"`, -1)
+// checkCollisions fails if posts of two notes would be written to the same file
+func checkCollisions(target Target, notes []*cachedNote) error {
+	titles := map[string]string{}
+	for _, n := range notes {
+		postPath := path.Clean(target.PostPath(n.post))
+		if title, exists := titles[postPath]; exists {
+			return errors.Errorf("notes %q and %q are both converted to %v, change one of the titles or the slug pattern", title, n.post.Title, postPath)
+		}
+		titles[postPath] = n.post.Title
+	}
+	return nil
+}
 
-	reader := bytes.NewReader([]byte(*enml))
-	doc, _ := goquery.NewDocumentFromReader(reader)
+func convertNotes(w *fileWriter, target Target, pipeline []namedTransformer, opts *Options, notebookName string, notefiles []os.FileInfo, resourceFiles []os.FileInfo, noteCacheDir string, resourceCacheDir string, siteRoot string) error {
+	notes, err := loadCachedNotes(opts, notebookName, notefiles, noteCacheDir)
+	if err != nil {
+		return err
+	}
+	if err := checkCollisions(target, notes); err != nil {
+		return err
+	}
 
-	doc.Find("en-todo").Each(func(_ int, todo *goquery.Selection) {
-		if _, exists := todo.Attr("checked"); exists {
-			todo.ReplaceWithHtml(`<input type="checkbox" checked="checked"/>`)
-		} else {
-			todo.ReplaceWithHtml(`<input type="checkbox"/>`)
+	// old urls which are used by other posts now aren't redirected
+	currentURLs := map[string]bool{}
+	for _, n := range notes {
+		if n.post.Published {
+			currentURLs[target.URL(n.post)] = true
 		}
-	})
+	}
 
-	for {
-		codeOpen := doc.Find("div:contains(\\`\\`\\`)").First()
-		if len(codeOpen.Nodes) == 0 {
-			break
+	for _, n := range notes {
+		post := n.post
+
+		// urls are recorded only while notes are published, so that unpublished posts aren't redirected to
+		url := target.URL(post)
+		var redirects []string
+		if post.Published {
+			for _, oldURL := range w.formerURLs(n.guid, url) {
+				if !currentURLs[oldURL] {
+					redirects = append(redirects, oldURL)
+				}
+			}
+		}
+		// encrypted sections depend on the passphrase, which isn't recorded
+		encrypted := n.doc.Find("en-crypt").Length() > 0
+		if !encrypted && w.noteUnchanged(n.guid, n.usn, post.Published, redirects) {
+			continue
+		}
+		if opts.Redirects == "" || opts.Redirects == redirectFrontMatter {
+			post.RedirectFrom = redirects
 		}
-		codeClose := codeOpen.NextAllFiltered("div:contains(\\`\\`\\`)").First()
 
-		if len(codeClose.Nodes) == 0 {
-			return nil, errors.Errorf("can't find code block end")
+		doc := n.doc
+		ctx := &TransformContext{Post: post, Target: target, Options: opts, Resources: resourceFiles}
+		if err := transform(doc, pipeline, ctx); err != nil {
+			return errors.Wrapf(err, "can't convert note %v", n.path)
 		}
 
-		language := strings.Replace(codeOpen.Text(), "```", "", 1)
-		codeLines := make([]string, 0, 10)
-		codeLines = append(codeLines, `<div>{% highlight `+language+` %}`)
+		var body *string
+		if opts.Format == formatMarkdown {
+			var codeBlock func(language string, code string) string
+			if opts.Highlight.Mode != "" {
+				codeBlock = opts.Highlight.codeBlock
+			} else if opts.HighlightTags {
+				codeBlock = target.CodeBlock
+			}
+			body = renderMarkdown(doc.Find("en-note"), codeBlock)
+		} else {
+			codeBlock := target.CodeBlock
+			if opts.Highlight.Mode != "" {
+				codeBlock = opts.Highlight.codeBlock
+			}
+			body, err = renderHTML(doc, codeBlock)
+			if err != nil {
+				return errors.Wrapf(err, "can't render note %v", n.path)
+			}
+		}
 
-		codeOpen.NextUntilSelection(codeClose).Each(func(_ int, line *goquery.Selection) {
-			codeLines = append(codeLines, line.Text())
-			line.Remove()
-		})
+		fm, err := target.FrontMatter(post)
+		if err != nil {
+			return errors.Wrap(err, "can't create front matter")
+		}
 
-		codeLines = append(codeLines, `{% endhighlight %}</div>`)
-		codeOpen.ReplaceWithHtml(strings.Join(codeLines, "\n"))
-		codeClose.Remove()
-	}
-
-	doc.Find(`div:contains("#")`).Each(func(_ int, div *goquery.Selection) {
-		line := strings.Replace(div.Text(), "\u00a0", " ", -1)
-		if strings.HasPrefix(line, "##### ") {
-			div.ReplaceWithHtml("<h5>" + strings.Replace(line, "##### ", "", 1))
-		} else if strings.HasPrefix(line, "#### ") {
-			div.ReplaceWithHtml("<h4>" + strings.Replace(line, "#### ", "", 1))
-		} else if strings.HasPrefix(line, "### ") {
-			div.ReplaceWithHtml("<h3>" + strings.Replace(line, "### ", "", 1))
-		} else if strings.HasPrefix(line, "## ") {
-			div.ReplaceWithHtml("<h2>" + strings.Replace(line, "## ", "", 1))
-		} else if strings.HasPrefix(line, "# ") {
-			div.ReplaceWithHtml("<h1>" + strings.Replace(line, "# ", "", 1))
-		}
-	})
-
-	doc.Find("img[en-media]").Each(func(i int, selection *goquery.Selection) {
-		hash, _ := selection.Attr("hash")
-		found := false
-		for _, resourceFile := range *resourceFiles {
-			if !strings.HasPrefix(resourceFile.Name(), hash) {
-				continue
-			}
+		*body = string(fm) + *body
 
-			found = true
-			lowerName := strings.ToLower(resourceFile.Name())
-			url := "{{ site.baseurl }}/" + path.Join(*jekyllResourcesDirName, resourceFile.Name())
+		notePath := path.Join(siteRoot, target.PostPath(post))
+		if err := w.writeFile(notePath, []byte(*body)); err != nil {
+			return errors.Wrapf(err, "can't create note file %v", notePath)
+		}
+		generatedFiles := []string{notePath}
+		if post.Published {
+			w.publishedAt(n.guid, url)
+		}
 
-			if strings.HasSuffix(lowerName, ".png") || strings.HasSuffix(lowerName, ".jpg") || strings.HasSuffix(lowerName, ".gif") {
-				selection.ReplaceWithHtml(fmt.Sprintf(`<img src="%v" />`, url))
-			} else if strings.HasSuffix(lowerName, ".mp3") {
-				selection.ReplaceWithHtml(fmt.Sprintf(`<audio src="%v" controls="true"/>`, url))
-			} else if strings.HasSuffix(lowerName, ".mp4") {
-				selection.ReplaceWithHtml(fmt.Sprintf(`<video src="%v" controls="true"/>`, url))
-			} else {
-				selection.ReplaceWithHtml(fmt.Sprintf(`<a src="%v" />`, url))
+		if opts.Redirects == redirectStub {
+			for _, oldURL := range redirects {
+				stubPath := path.Join(siteRoot, target.RedirectPath(oldURL))
+				if err := w.writeFile(stubPath, redirectStubHTML(url)); err != nil {
+					return errors.Wrapf(err, "can't create redirect %v", stubPath)
+				}
+				generatedFiles = append(generatedFiles, stubPath)
 			}
 		}
 
-		if !found {
-			fmt.Printf("can't find resource %v\n", hash)
+		// resources of unpublished notes must not leak to the public site
+		if post.Published {
+			noteResourcesDir := path.Join(siteRoot, target.ResourceDir(post))
+			for _, resourceFileName := range ctx.ReferencedResources {
+				if err := copyResourceFile(w, resourceCacheDir, noteResourcesDir, resourceFileName); err != nil {
+					return err
+				}
+				generatedFiles = append(generatedFiles, path.Join(noteResourcesDir, resourceFileName))
+			}
 		}
-	})
 
-	innerNoteHTML, _ := doc.Find("en-note").Html()
-	return &innerNoteHTML, nil
+		w.recordNote(n.guid, noteRecord{
+			UpdateSequenceNum: n.usn,
+			Published:         post.Published,
+			Redirects:         redirects,
+			Dirs:              postDirs(target, siteRoot, post),
+			Files:             generatedFiles,
+		})
+	}
+
+	return nil
 }
 
-func copyResourceFile(from string, to string, fileName string) error {
-	sourcePath := path.Join(from, fileName)
-	source, err := os.OpenFile(sourcePath, os.O_RDONLY, os.ModePerm)
-	if err != nil {
-		return errors.Wrapf(err, "can't open resource cache file %v", sourcePath)
+// postDirs returns directories which belong only to the post, like page bundles of hugo.
+// They are the directories which change with the slug and the guid of the post.
+func postDirs(target Target, siteRoot string, post *Post) []string {
+	other := *post
+	other.Slug, other.GUID = post.Slug+"-other", post.GUID+"-other"
+	var dirs []string
+	for _, dir := range []string{path.Dir(target.PostPath(post)), target.ResourceDir(post)} {
+		dir = path.Join(siteRoot, dir)
+		if dir == path.Join(siteRoot, path.Dir(target.PostPath(&other))) || dir == path.Join(siteRoot, target.ResourceDir(&other)) {
+			continue
+		}
+		if len(dirs) == 0 || dirs[0] != dir {
+			dirs = append(dirs, dir)
+		}
 	}
-	defer source.Close()
+	return dirs
+}
 
-	destPath := path.Join(to, fileName)
-	dest, err := os.OpenFile(destPath, os.O_CREATE, os.ModePerm)
-	if err != nil {
-		return errors.Wrapf(err, "can't create resource file %v", sourcePath)
+func mediaKind(fileName string) string {
+	lowerName := strings.ToLower(fileName)
+	if strings.HasSuffix(lowerName, ".png") || strings.HasSuffix(lowerName, ".jpg") || strings.HasSuffix(lowerName, ".gif") {
+		return mediaImage
+	} else if strings.HasSuffix(lowerName, ".mp3") {
+		return mediaAudio
+	} else if strings.HasSuffix(lowerName, ".mp4") {
+		return mediaVideo
 	}
-	defer dest.Close()
+	return mediaFile
+}
 
-	if _, err := io.Copy(dest, source); err != nil {
-		return errors.Wrapf(err, "can't copy resource file %v", sourcePath)
+// resourceDisplayName removes the hash prefix from the name of a cached resource file
+func resourceDisplayName(fileName string) string {
+	if i := strings.Index(fileName, "-"); i >= 0 {
+		return fileName[i+1:]
 	}
+	return fileName
+}
 
-	return nil
+func copyResourceFile(w *fileWriter, from string, to string, fileName string) error {
+	destPath := path.Join(to, fileName)
+	if w.resourceUnchanged(destPath) {
+		return nil
+	}
+	return w.copyFile(path.Join(from, fileName), destPath)
 }
@@ -0,0 +1,211 @@
+package convert
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// passphraseEnv is the environment variable of the passphrase, which is better than writing it in the configuration file
+const passphraseEnv = "CHIENOTE_PASSPHRASE"
+
+const defaultEncryptedPlaceholder = `<p><em>This section is encrypted.</em></p>`
+
+// EncryptionOptions configure encrypted sections of notes
+type EncryptionOptions struct {
+	// Passphrase decrypts encrypted sections. CHIENOTE_PASSPHRASE environment variable overrides it.
+	Passphrase string `yaml:"passphrase,omitempty"`
+
+	// Placeholder is html written instead of sections which can't be decrypted, with {{hint}} of the passphrase
+	Placeholder string `yaml:"placeholder,omitempty"`
+}
+
+func (opts *EncryptionOptions) validate() error {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		opts.Passphrase = passphrase
+	}
+	_, err := opts.placeholder("")
+	return err
+}
+
+func (opts *EncryptionOptions) placeholder(hint string) (string, error) {
+	placeholder := opts.Placeholder
+	if placeholder == "" {
+		placeholder = defaultEncryptedPlaceholder
+	}
+	return expandPattern(placeholder, map[string]string{"hint": html.EscapeString(hint)})
+}
+
+// transformDecrypt replaces en-crypt with its decrypted content, or with the placeholder without the passphrase
+func transformDecrypt(doc *goquery.Document, ctx *TransformContext) error {
+	opts := &ctx.Options.Encryption
+	for _, n := range doc.Find("en-crypt").Nodes {
+		var nodes []*nethtml.Node
+		if opts.Passphrase != "" {
+			decrypted, err := decryptedNodes(n, opts.Passphrase)
+			if err != nil {
+				fmt.Printf("can't decrypt a section of %v: %v\n", ctx.Post.Title, err)
+			}
+			nodes = decrypted
+		}
+		if len(nodes) == 0 {
+			placeholder, err := opts.placeholder(attr(n, "hint"))
+			if err != nil {
+				return err
+			}
+			nodes, err = nethtml.ParseFragment(strings.NewReader(placeholder), &nethtml.Node{Type: nethtml.ElementNode, Data: "div", DataAtom: atom.Div})
+			if err != nil {
+				return errors.Wrap(err, "can't parse placeholder")
+			}
+		}
+
+		for _, node := range nodes {
+			n.Parent.InsertBefore(node, n)
+		}
+		n.Parent.RemoveChild(n)
+	}
+	return nil
+}
+
+// decryptedNodes decrypts en-crypt and parses its content, which is ENML without en-note
+func decryptedNodes(n *nethtml.Node, passphrase string) ([]*nethtml.Node, error) {
+	content, err := decryptSection(attr(n, "cipher"), attr(n, "length"), textContent(n), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseENML("<en-note>" + content + "</en-note>")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't parse decrypted section")
+	}
+
+	var nodes []*nethtml.Node
+	for _, note := range doc.Find("en-note").Nodes {
+		for note.FirstChild != nil {
+			child := note.FirstChild
+			note.RemoveChild(child)
+			nodes = append(nodes, child)
+		}
+	}
+	return nodes, nil
+}
+
+// decryptSection decrypts the content of en-crypt into ENML.
+// cipher and length are attributes of en-crypt, which are RC2 and 64 if they are blank.
+func decryptSection(cipherName string, length string, content string, passphrase string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(content), ""))
+	if err != nil {
+		return "", errors.Wrap(err, "can't decode encrypted section")
+	}
+
+	bits := 64
+	if cipherName == "AES" {
+		bits = 128
+	}
+	if length != "" {
+		if bits, err = strconv.Atoi(length); err != nil {
+			return "", errors.Errorf("invalid key length %v", length)
+		}
+	}
+
+	switch cipherName {
+	case "", "RC2":
+		return decryptRC2(data, passphrase, bits)
+	case "AES":
+		return decryptAES(data, passphrase, bits)
+	}
+	return "", errors.Errorf("unknown cipher %v", cipherName)
+}
+
+// decryptRC2 decrypts sections of old Evernote, which are text encrypted with RC2 whose key is the MD5 of the passphrase
+func decryptRC2(data []byte, passphrase string, bits int) (string, error) {
+	key := md5.Sum([]byte(passphrase))
+	decrypted, err := rc2Decrypt(key[:], bits, data)
+	if err != nil {
+		return "", err
+	}
+	text, err := rc2Text(decrypted)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(html.EscapeString(text), "\n", "<br/>", -1), nil
+}
+
+// rc2Text returns the text of a decrypted RC2 section, which is padded with zeros.
+// It starts with a checksum, the first 4 upper case hex digits of CRC32 of the rest, which may be without leading zeros.
+func rc2Text(decrypted []byte) (string, error) {
+	if len(decrypted) < 4 {
+		return "", errors.Errorf("encrypted section is too short")
+	}
+	checksum := string(decrypted[:4])
+	sum := crc32.ChecksumIEEE(decrypted[4:])
+	unpadded := fmt.Sprintf("%X", sum)
+	if checksum != fmt.Sprintf("%08X", sum)[:4] && (len(unpadded) < 4 || checksum != unpadded[:4]) {
+		return "", errors.Errorf("wrong passphrase")
+	}
+
+	text := strings.TrimRight(string(decrypted[4:]), "\x00")
+	if !utf8.ValidString(text) {
+		return "", errors.Errorf("encrypted section is broken")
+	}
+	return text, nil
+}
+
+// aesIterations is the number of PBKDF2 iterations of Evernote's AES encryption
+const aesIterations = 50000
+
+// decryptAES decrypts sections of Evernote, which are html encrypted with AES in CBC mode.
+// The data is "ENC0", a salt of the key, a salt of the HMAC key, the IV, the encrypted html and HMAC-SHA256 of them,
+// and both keys are made from the passphrase with PBKDF2-HMAC-SHA256.
+func decryptAES(data []byte, passphrase string, bits int) (string, error) {
+	const saltSize, ivSize, macSize = 16, 16, 32
+	header := 4 + saltSize*2 + ivSize
+	if len(data) < header+macSize || !bytes.HasPrefix(data, []byte("ENC0")) {
+		return "", errors.Errorf("encrypted section is broken")
+	}
+	salt := data[4 : 4+saltSize]
+	hmacSalt := data[4+saltSize : 4+saltSize*2]
+	iv := data[4+saltSize*2 : header]
+	body := data[header : len(data)-macSize]
+	mac := data[len(data)-macSize:]
+
+	hmacKey := pbkdf2.Key([]byte(passphrase), hmacSalt, aesIterations, bits/8, sha256.New)
+	h := hmac.New(sha256.New, hmacKey)
+	h.Write(data[:len(data)-macSize])
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return "", errors.Errorf("wrong passphrase")
+	}
+
+	key := pbkdf2.Key([]byte(passphrase), salt, aesIterations, bits/8, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", errors.Wrap(err, "can't decrypt section")
+	}
+	if len(body) == 0 || len(body)%aes.BlockSize != 0 {
+		return "", errors.Errorf("encrypted section is broken")
+	}
+	decrypted := make([]byte, len(body))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, body)
+
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(decrypted) {
+		return "", errors.Errorf("encrypted section is broken")
+	}
+	return string(decrypted[:len(decrypted)-padding]), nil
+}
@@ -0,0 +1,100 @@
+package convert
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// enmlProhibitedElements aren't allowed by the ENML DTD, and are removed with their contents if they appear
+var enmlProhibitedElements = map[string]bool{
+	"applet": true, "base": true, "basefont": true, "bgsound": true, "blink": true, "body": true, "button": true,
+	"dir": true, "embed": true, "fieldset": true, "form": true, "frame": true, "frameset": true, "head": true,
+	"html": true, "iframe": true, "ilayer": true, "input": true, "isindex": true, "label": true, "layer": true,
+	"legend": true, "link": true, "marquee": true, "menu": true, "meta": true, "noframes": true, "noscript": true,
+	"object": true, "optgroup": true, "option": true, "param": true, "plaintext": true, "script": true,
+	"select": true, "style": true, "textarea": true, "xml": true,
+}
+
+// parseENML parses the content of a note as XML into an html document whose body has the en-note element.
+// en-media, en-todo and en-crypt are kept as elements with their attributes,
+// and XHTML entities like &nbsp; are resolved as the ENML DTD defines them.
+func parseENML(enml string) (*goquery.Document, error) {
+	decoder := xml.NewDecoder(strings.NewReader(enml))
+	decoder.Strict = true
+	decoder.Entity = xml.HTMLEntity
+
+	root := &html.Node{Type: html.DocumentNode}
+	htmlNode := newElement("html", nil)
+	body := newElement("body", nil)
+	root.AppendChild(htmlNode)
+	htmlNode.AppendChild(newElement("head", nil))
+	htmlNode.AppendChild(body)
+
+	parent := body
+	prohibited := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "can't parse ENML")
+		}
+
+		switch t := token.(type) {
+		case xml.Directive:
+			if doctype := string(t); strings.HasPrefix(doctype, "DOCTYPE") && !strings.HasPrefix(strings.TrimSpace(doctype[len("DOCTYPE"):]), "en-note") {
+				return nil, errors.Errorf("can't parse ENML: unknown document type %v", doctype)
+			}
+		case xml.StartElement:
+			if parent == body && t.Name.Local != "en-note" {
+				return nil, errors.Errorf("can't parse ENML: the root element is %v instead of en-note", t.Name.Local)
+			}
+			if prohibited > 0 || enmlProhibitedElements[t.Name.Local] {
+				prohibited++
+				continue
+			}
+			element := newElement(t.Name.Local, t.Attr)
+			parent.AppendChild(element)
+			parent = element
+		case xml.EndElement:
+			if prohibited > 0 {
+				prohibited--
+				continue
+			}
+			parent = parent.Parent
+		case xml.CharData:
+			if prohibited > 0 || parent == body {
+				continue
+			}
+			if last := parent.LastChild; last != nil && last.Type == html.TextNode {
+				last.Data += string(t)
+			} else {
+				parent.AppendChild(&html.Node{Type: html.TextNode, Data: string(t)})
+			}
+		case xml.Comment:
+			if prohibited == 0 && parent != body {
+				parent.AppendChild(&html.Node{Type: html.CommentNode, Data: string(t)})
+			}
+		}
+	}
+
+	if body.FirstChild == nil {
+		return nil, errors.Errorf("can't parse ENML: en-note is missing")
+	}
+	return goquery.NewDocumentFromNode(root), nil
+}
+
+func newElement(name string, attrs []xml.Attr) *html.Node {
+	n := &html.Node{Type: html.ElementNode, Data: name, DataAtom: atom.Lookup([]byte(name))}
+	for _, a := range attrs {
+		n.Attr = append(n.Attr, html.Attribute{Key: a.Name.Local, Val: a.Value})
+	}
+	return n
+}
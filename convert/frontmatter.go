@@ -0,0 +1,259 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	frontMatterYAML = "yaml"
+	frontMatterTOML = "toml"
+	frontMatterJSON = "json"
+)
+
+// frontMatter is an ordered map of front matter fields.
+// Dotted keys like "taxonomies.tags" are set into nested maps.
+type frontMatter struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newFrontMatter() *frontMatter {
+	return &frontMatter{values: map[string]interface{}{}}
+}
+
+func (fm *frontMatter) set(key string, value interface{}) {
+	if i := strings.Index(key, "."); i > 0 {
+		nested, ok := fm.values[key[:i]].(*frontMatter)
+		if !ok {
+			nested = newFrontMatter()
+			fm.set(key[:i], nested)
+		}
+		nested.set(key[i+1:], value)
+		return
+	}
+
+	if _, exists := fm.values[key]; !exists {
+		fm.keys = append(fm.keys, key)
+	}
+	fm.values[key] = normalizeValue(value)
+}
+
+// normalizeValue turns yaml mappings, e.g. in front matter of notes, into nested front matters
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		nested := newFrontMatter()
+		for _, item := range v {
+			key := fmt.Sprint(item.Key)
+			if _, exists := nested.values[key]; !exists {
+				nested.keys = append(nested.keys, key)
+			}
+			nested.values[key] = normalizeValue(item.Value)
+		}
+		return nested
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = normalizeValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+// marshal returns the front matter in format. Dates are formatted with dateFormat except in TOML.
+func (fm *frontMatter) marshal(format string, dateFormat string) ([]byte, error) {
+	switch format {
+	case "", frontMatterYAML:
+		if len(fm.keys) == 0 {
+			return []byte{}, nil
+		}
+		yamlBytes, err := yaml.Marshal(fm.yamlValue(dateFormat))
+		if err != nil {
+			return nil, errors.Wrap(err, "can't marshal front matter as YAML")
+		}
+		return yamlBytes, nil
+	case frontMatterTOML:
+		var buf bytes.Buffer
+		if err := fm.writeTOML(&buf, ""); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case frontMatterJSON:
+		jsonBytes, err := json.MarshalIndent(fm.jsonValue(dateFormat), "", "  ")
+		if err != nil {
+			return nil, errors.Wrap(err, "can't marshal front matter as JSON")
+		}
+		return append(jsonBytes, '\n'), nil
+	}
+	return nil, errors.Errorf("unknown front matter format %v", format)
+}
+
+func (fm *frontMatter) yamlValue(dateFormat string) yaml.MapSlice {
+	slice := make(yaml.MapSlice, 0, len(fm.keys))
+	for _, key := range fm.keys {
+		slice = append(slice, yaml.MapItem{Key: key, Value: formatValue(fm.values[key], dateFormat, false)})
+	}
+	return slice
+}
+
+// formatValue formats dates and nested front matters in value for yaml or json
+func formatValue(value interface{}, dateFormat string, json bool) interface{} {
+	switch v := value.(type) {
+	case *frontMatter:
+		if json {
+			return v.jsonValue(dateFormat)
+		}
+		return v.yamlValue(dateFormat)
+	case time.Time:
+		return v.Format(dateFormat)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = formatValue(item, dateFormat, json)
+		}
+		return items
+	}
+	return value
+}
+
+// orderedJSON keeps the order of keys in JSON
+type orderedJSON struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o orderedJSON) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyBytes, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		valueBytes, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(keyBytes)
+		buf.WriteByte(':')
+		buf.Write(valueBytes)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (fm *frontMatter) jsonValue(dateFormat string) orderedJSON {
+	o := orderedJSON{keys: fm.keys, values: map[string]interface{}{}}
+	for _, key := range fm.keys {
+		o.values[key] = formatValue(fm.values[key], dateFormat, true)
+	}
+	return o
+}
+
+// writeTOML writes values first and then nested tables, as TOML requires
+func (fm *frontMatter) writeTOML(buf *bytes.Buffer, table string) error {
+	var tables []string
+	for _, key := range fm.keys {
+		if _, ok := fm.values[key].(*frontMatter); ok {
+			tables = append(tables, key)
+			continue
+		}
+		// TOML has no null
+		if fm.values[key] == nil {
+			continue
+		}
+		value, err := tomlValue(fm.values[key])
+		if err != nil {
+			return errors.Wrapf(err, "can't marshal front matter %v as TOML", key)
+		}
+		buf.WriteString(tomlKey(key) + " = " + value + "\n")
+	}
+
+	for _, key := range tables {
+		name := tomlKey(key)
+		if table != "" {
+			name = table + "." + name
+		}
+		buf.WriteString("\n[" + name + "]\n")
+		if err := fm.values[key].(*frontMatter).writeTOML(buf, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tomlKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			quoted, _ := json.Marshal(key)
+			return string(quoted)
+		}
+	}
+	return key
+}
+
+func tomlValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case []string:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i], _ = tomlValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			value, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = value
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case *frontMatter:
+		items := make([]string, len(v.keys))
+		for i, key := range v.keys {
+			value, err := tomlValue(v.values[key])
+			if err != nil {
+				return "", err
+			}
+			items[i] = tomlKey(key) + " = " + value
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			value, err := tomlValue(v[key])
+			if err != nil {
+				return "", err
+			}
+			items[i] = tomlKey(key) + " = " + value
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	case string, bool, int, int32, int64, float32, float64:
+		// JSON strings and numbers are also valid in TOML
+		jsonBytes, err := json.Marshal(v)
+		return string(jsonBytes), err
+	}
+	return "", errors.Errorf("unsupported value %v", value)
+}
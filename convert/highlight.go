@@ -0,0 +1,93 @@
+package convert
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/pkg/errors"
+)
+
+const (
+	highlightClasses = "classes"
+	highlightInline  = "inline"
+)
+
+const defaultHighlightStyle = "github"
+
+// HighlightOptions configure highlighting code blocks while converting
+type HighlightOptions struct {
+	// Mode is "classes" to write html with css classes, which need a stylesheet made by the stylesheet command,
+	// or "inline" to write html with inline styles.
+	// Code blocks are left to the code block template of the target if it is blank.
+	Mode string `yaml:"mode,omitempty"`
+
+	// Style is a chroma style like "monokai", "github" by default
+	Style string `yaml:"style,omitempty"`
+
+	// LineNumbers adds line numbers to code blocks
+	LineNumbers bool `yaml:"line_numbers,omitempty"`
+}
+
+func (opts *HighlightOptions) validate() error {
+	switch opts.Mode {
+	case "", highlightClasses, highlightInline:
+	default:
+		return errors.Errorf("unknown highlight mode %v", opts.Mode)
+	}
+	if _, err := opts.style(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (opts *HighlightOptions) style() (*chroma.Style, error) {
+	name := opts.Style
+	if name == "" {
+		name = defaultHighlightStyle
+	}
+	style, ok := styles.Registry[name]
+	if !ok {
+		return nil, errors.Errorf("unknown highlight style %v (available: %v)", name, styles.Names())
+	}
+	return style, nil
+}
+
+func (opts *HighlightOptions) formatter() *chromahtml.Formatter {
+	return chromahtml.New(chromahtml.WithClasses(opts.Mode != highlightInline), chromahtml.WithLineNumbers(opts.LineNumbers))
+}
+
+// codeBlock returns highlighted html of code, or "" to write a plain code block if it can't be highlighted
+func (opts *HighlightOptions) codeBlock(language string, code string) string {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	style, err := opts.style()
+	if err != nil {
+		return ""
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if err := opts.formatter().Format(&buf, style, iterator); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// WriteStylesheet writes css for code blocks highlighted with classes
+func WriteStylesheet(w io.Writer, opts Options) error {
+	style, err := opts.Highlight.style()
+	if err != nil {
+		return err
+	}
+	opts.Highlight.Mode = highlightClasses
+	return errors.Wrap(opts.Highlight.formatter().WriteCSS(w, style), "can't write stylesheet")
+}
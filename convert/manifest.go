@@ -0,0 +1,93 @@
+package convert
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/pkg/errors"
+)
+
+const manifestFileName = "manifest.yml"
+
+// outputVersion must be increased when the output format changes so that every note is converted again
+const outputVersion = "22"
+
+// hashConfig returns a hash of everything other than notes which affects the output
+func hashConfig(values ...string) string {
+	hash := sha1.New()
+	hash.Write([]byte(outputVersion))
+	for _, value := range values {
+		hash.Write([]byte{0})
+		hash.Write([]byte(value))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// manifest records files generated by convert.
+// Only files listed in the previous manifest are overwritten or removed on the next run.
+// Notes whose update sequence number and config hash are unchanged aren't converted again.
+// URLs are every url each note has been published at, the last one being the current, to redirect old urls.
+type manifest struct {
+	ConfigHash string                `yaml:"config_hash"`
+	Files      []string              `yaml:"files"`
+	Notes      map[string]noteRecord `yaml:"notes,omitempty"`
+	URLs       map[string][]string   `yaml:"urls,omitempty"`
+}
+
+// noteRecord is the state of a converted note.
+// Dirs are directories which belong only to the note, which are removed with its files.
+type noteRecord struct {
+	UpdateSequenceNum int32    `yaml:"usn"`
+	Published         bool     `yaml:"published,omitempty"`
+	Redirects         []string `yaml:"redirects,omitempty"`
+	Dirs              []string `yaml:"dirs,omitempty"`
+	Files             []string `yaml:"files"`
+}
+
+// loadManifest returns nil if convert has never been run
+func loadManifest(manifestPath string) (*manifest, error) {
+	manifestBytes, err := ioutil.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read manifest %v", manifestPath)
+	}
+
+	m := &manifest{}
+	if err := yaml.Unmarshal(manifestBytes, m); err != nil {
+		return nil, errors.Wrapf(err, "can't unmarshal manifest %v", manifestPath)
+	}
+	return m, nil
+}
+
+func (m *manifest) owns(p string) bool {
+	for _, file := range m.Files {
+		if file == p {
+			return true
+		}
+	}
+	return false
+}
+
+func saveManifest(manifestPath string, configHash string, files map[string]bool, notes map[string]noteRecord, urls map[string][]string) error {
+	m := manifest{ConfigHash: configHash, Files: make([]string, 0, len(files)), Notes: notes, URLs: urls}
+	for file := range files {
+		m.Files = append(m.Files, file)
+	}
+	sort.Strings(m.Files)
+
+	manifestBytes, err := yaml.Marshal(m)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal manifest %v", manifestPath)
+	}
+	if err := ioutil.WriteFile(manifestPath, manifestBytes, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "can't write manifest %v", manifestPath)
+	}
+	return nil
+}
@@ -0,0 +1,465 @@
+package convert
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// markdownRenderer renders the converted note as CommonMark with GitHub flavored tables and task lists.
+// Each evernote line becomes a line of a paragraph, and blank lines separate paragraphs.
+// Code blocks are rendered by codeBlock if it returns a template tag, or as fenced code blocks otherwise.
+type markdownRenderer struct {
+	codeBlockTemplate func(language string, code string) string
+}
+
+func renderMarkdown(note *goquery.Selection, codeBlock func(language string, code string) string) *string {
+	r := markdownRenderer{codeBlockTemplate: codeBlock}
+
+	var blocks []string
+	for _, n := range note.Nodes {
+		blocks = append(blocks, r.blocks(n)...)
+	}
+
+	markdown := strings.Join(blocks, "\n\n") + "\n"
+	return &markdown
+}
+
+var markdownBlockElements = map[string]bool{
+	"div": true, "p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "table": true, "pre": true, "hr": true, "blockquote": true,
+	"center": true, "section": true, "article": true,
+}
+
+func isBlock(n *html.Node) bool {
+	return n.Type == html.ElementNode && markdownBlockElements[n.Data]
+}
+
+func hasBlockChild(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isBlock(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTodo(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+			continue
+		}
+		return c.Type == html.ElementNode && c.Data == "input" && attr(c, "type") == "checkbox"
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// blocks renders children of parent as markdown blocks
+func (r *markdownRenderer) blocks(parent *html.Node) []string {
+	var blocks []string
+	var lines []string
+	var tasks []string
+	var run bytes.Buffer
+
+	flush := func() {
+		if len(lines) > 0 {
+			blocks = append(blocks, strings.Join(lines, "\\\n"))
+			lines = nil
+		}
+		if len(tasks) > 0 {
+			blocks = append(blocks, strings.Join(tasks, "\n"))
+			tasks = nil
+		}
+	}
+	addLine := func(line string) {
+		if len(tasks) > 0 {
+			flush()
+		}
+		lines = append(lines, hardBreaks(escapeLineStart(line), ""))
+	}
+	endRun := func() {
+		if line := trimLine(run.String()); line != "" {
+			addLine(line)
+		}
+		run.Reset()
+	}
+
+	for c := parent.FirstChild; c != nil; c = c.NextSibling {
+		if !isBlock(c) {
+			run.WriteString(r.inline(c))
+			continue
+		}
+		endRun()
+
+		switch c.Data {
+		case "div", "center", "section", "article":
+			if hasBlockChild(c) {
+				flush()
+				blocks = append(blocks, r.blocks(c)...)
+				continue
+			}
+			if isTodo(c) {
+				if len(lines) > 0 {
+					flush()
+				}
+				tasks = append(tasks, "- "+hardBreaks(trimLine(r.inlineChildren(c)), "  "))
+				continue
+			}
+			line := trimLine(r.inlineChildren(c))
+			if line == "" {
+				flush()
+				continue
+			}
+			addLine(line)
+		case "p":
+			flush()
+			if hasBlockChild(c) {
+				blocks = append(blocks, r.blocks(c)...)
+			} else if line := trimLine(r.inlineChildren(c)); line != "" {
+				blocks = append(blocks, hardBreaks(escapeLineStart(line), ""))
+			}
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			flush()
+			level := int(c.Data[1] - '0')
+			heading := strings.Replace(trimLine(r.inlineChildren(c)), "\n", " ", -1)
+			blocks = append(blocks, strings.Repeat("#", level)+" "+heading)
+		case "ul", "ol":
+			flush()
+			blocks = append(blocks, strings.Join(r.list(c, ""), "\n"))
+		case "table":
+			flush()
+			if table := r.table(c); table != "" {
+				blocks = append(blocks, table)
+			}
+		case "pre":
+			flush()
+			blocks = append(blocks, r.codeBlock(c))
+		case "hr":
+			flush()
+			blocks = append(blocks, "---")
+		case "blockquote":
+			flush()
+			quoted := strings.Split(strings.Join(r.blocks(c), "\n\n"), "\n")
+			for i, line := range quoted {
+				quoted[i] = strings.TrimRight("> "+line, " ")
+			}
+			blocks = append(blocks, strings.Join(quoted, "\n"))
+		}
+	}
+	endRun()
+	flush()
+
+	return blocks
+}
+
+func (r *markdownRenderer) inlineChildren(n *html.Node) string {
+	var buf bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(r.inline(c))
+	}
+	return buf.String()
+}
+
+func (r *markdownRenderer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		text := strings.Replace(n.Data, "\u00a0", " ", -1)
+		return escapeMarkdown(markdownSpaces.ReplaceAllString(text, " "))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "br":
+		return "\n"
+	case "b", "strong":
+		return wrapInline(r.inlineChildren(n), "**")
+	case "i", "em":
+		return wrapInline(r.inlineChildren(n), "*")
+	case "s", "strike", "del":
+		return wrapInline(r.inlineChildren(n), "~~")
+	case "code", "tt":
+		return inlineCode(textContent(n))
+	case "a":
+		href := attr(n, "href")
+		if href == "" {
+			href = attr(n, "src")
+		}
+		text := trimLine(r.inlineChildren(n))
+		if text == "" {
+			text = escapeMarkdown(path.Base(href))
+		}
+		if href == "" {
+			return text
+		}
+		return "[" + text + "](" + escapeURL(href) + ")"
+	case "img":
+		return "![" + escapeMarkdown(attr(n, "alt")) + "](" + escapeURL(attr(n, "src")) + ")"
+	case "input":
+		if attr(n, "type") != "checkbox" {
+			return ""
+		}
+		if hasAttr(n, "checked") {
+			return "[x] "
+		}
+		return "[ ] "
+	case "audio", "video":
+		var buf bytes.Buffer
+		html.Render(&buf, n)
+		return buf.String()
+	case "script", "style":
+		return ""
+	case rawElement:
+		return attr(n, "data-raw")
+	}
+
+	return r.inlineChildren(n)
+}
+
+// list renders items of ul or ol with nested lists indented under their items
+func (r *markdownRenderer) list(n *html.Node, indent string) []string {
+	var lines []string
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		number = start
+	}
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+
+		marker := "- "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+
+		var text bytes.Buffer
+		var nested []*html.Node
+		for c := li.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "ul" || c.Data == "ol") {
+				nested = append(nested, c)
+				continue
+			}
+			if isBlock(c) && text.Len() > 0 {
+				text.WriteString("\n")
+			}
+			text.WriteString(r.inline(c))
+		}
+
+		childIndent := indent + strings.Repeat(" ", len(marker))
+		item := hardBreaks(trimLine(text.String()), childIndent)
+		lines = append(lines, indent+marker+item)
+		for _, list := range nested {
+			lines = append(lines, r.list(list, childIndent)...)
+		}
+	}
+	return lines
+}
+
+// table renders a GitHub flavored table.
+// The header is empty unless the first row is in <thead> or made of <th>.
+func (r *markdownRenderer) table(n *html.Node) string {
+	var rows [][]string
+	header := false
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if c.Data != "tr" {
+				walk(c)
+				continue
+			}
+			if len(rows) == 0 {
+				header = c.Parent.Data == "thead" || onlyHeaderCells(c)
+			}
+			var row []string
+			for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+					row = append(row, strings.Replace(trimLine(r.tableCell(cell)), "\n", "<br>", -1))
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+	if !header {
+		rows = append([][]string{nil}, rows...)
+	}
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+
+	var lines []string
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *markdownRenderer) tableCell(cell *html.Node) string {
+	var buf bytes.Buffer
+	for c := cell.FirstChild; c != nil; c = c.NextSibling {
+		if isBlock(c) && buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		if isBlock(c) {
+			buf.WriteString(r.inlineChildren(c))
+		} else {
+			buf.WriteString(r.inline(c))
+		}
+	}
+	return buf.String()
+}
+
+func (r *markdownRenderer) codeBlock(pre *html.Node) string {
+	language := attr(pre, "data-lang")
+	code := strings.TrimRight(textContent(pre), "\n")
+
+	if r.codeBlockTemplate != nil {
+		if codeBlock := r.codeBlockTemplate(language, code); codeBlock != "" {
+			return codeBlock
+		}
+	}
+
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + language + "\n" + code + "\n" + fence
+}
+
+// inlineCode wraps code in a run of backticks longer than any run in it.
+// Spaces are added inside when code starts or ends with a backtick, and markdown strips them.
+func inlineCode(code string) string {
+	longest, run := 0, 0
+	for _, c := range code {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") ||
+		(strings.HasPrefix(code, " ") && strings.HasSuffix(code, " ") && strings.TrimSpace(code) != "") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var buf bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(textContent(c))
+	}
+	return buf.String()
+}
+
+func wrapInline(text string, mark string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	// emphasis can't start or end with spaces, so they are moved out of it
+	leading := text[:strings.Index(text, trimmed)]
+	trailing := text[len(leading)+len(trimmed):]
+	return leading + mark + trimmed + mark + trailing
+}
+
+// trimLine trims spaces and line breaks around a line
+func trimLine(line string) string {
+	return strings.TrimSpace(line)
+}
+
+// hardBreaks turns line breaks in a line into hard line breaks followed by indent
+func hardBreaks(line string, indent string) string {
+	return strings.Replace(line, "\n", "\\\n"+indent, -1)
+}
+
+var markdownSpaces = regexp.MustCompile(`[ \t\r\n]+`)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "~", `\~`, "|", `\|`,
+)
+
+// markdownEntity is an entity or a character reference, which markdown turns into the character
+var markdownEntity = regexp.MustCompile(`&(#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+
+func escapeMarkdown(text string) string {
+	return markdownEntity.ReplaceAllString(markdownEscaper.Replace(text), `\$0`)
+}
+
+// escapeLineStart escapes characters which start a block at the beginning of a line
+func escapeLineStart(line string) string {
+	if line == "" {
+		return line
+	}
+	switch line[0] {
+	case '#', '-', '+', '=':
+		return `\` + line
+	}
+	for i := 0; i < len(line); i++ {
+		if line[i] >= '0' && line[i] <= '9' {
+			continue
+		}
+		if i > 0 && (line[i] == '.' || line[i] == ')') {
+			return line[:i] + `\` + line[i:]
+		}
+		break
+	}
+	return line
+}
+
+// escapeURL encloses url in angle brackets if it has spaces, e.g. {{ site.baseurl }}
+func escapeURL(url string) string {
+	if strings.ContainsAny(url, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(url) + ">"
+	}
+	return url
+}
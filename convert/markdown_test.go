@@ -0,0 +1,69 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func renderMarkdownString(t *testing.T, note string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(note))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *renderMarkdown(doc.Find("en-note"), nil)
+}
+
+func TestRenderMarkdownEscape(t *testing.T) {
+	tests := []struct {
+		note string
+		want string
+	}{
+		{`<en-note><div>&amp;lt;b&amp;gt; is a tag</div></en-note>`, `\&lt;b\&gt; is a tag`},
+		{`<en-note><div>&amp;#60; and &amp;#x3C;</div></en-note>`, `\&#60; and \&#x3C;`},
+		{`<en-note><div>AT&amp;T &amp; co; a&amp;b</div></en-note>`, `AT&T & co; a&b`},
+	}
+	for _, test := range tests {
+		if got := strings.TrimSpace(renderMarkdownString(t, test.note)); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.note, got, test.want)
+		}
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		note string
+		want string
+	}{
+		{`<en-note><div>*not emphasis* and 1. not a list</div></en-note>`, `\*not emphasis\* and 1. not a list`},
+		{`<en-note><div># not a heading</div></en-note>`, `\# not a heading`},
+		{`<en-note><div><code>a ` + "`" + `b</code></div></en-note>`, "``a `b``"},
+		{`<en-note><div><a href="https://example.com/a_(b)">link</a></div></en-note>`, `[link](<https://example.com/a_(b)>)`},
+		{`<en-note><ol start="3"><li>three</li><li>four</li></ol></en-note>`, "3. three\n4. four"},
+	}
+	for _, test := range tests {
+		if got := strings.TrimSpace(renderMarkdownString(t, test.note)); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.note, got, test.want)
+		}
+	}
+}
+
+func TestInlineMarkdown(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`see [wiki](https://en.wikipedia.org/wiki/Go_(language)) here`, `see <a href="https://en.wikipedia.org/wiki/Go_(language)">wiki</a> here`},
+		{`[spaces](<https://example.com/a b>)`, `<a href="https://example.com/a b">spaces</a>`},
+		{`([link](https://example.com))`, `(<a href="https://example.com">link</a>)`},
+		// text without markdown is left as is
+		{`[unbalanced](https://example.com/(a)`, ``},
+		{`a <b> & **c**`, `a &lt;b&gt; &amp; <strong>c</strong>`},
+	}
+	for _, test := range tests {
+		if got, _ := inlineMarkdown(test.text); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
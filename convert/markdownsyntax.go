@@ -0,0 +1,396 @@
+package convert
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// transformMarkdownSyntax converts markdown written as plain text in notes into html.
+// Lines of quotes, lists, horizontal rules and pipe tables become blocks, and then
+// bold, italic, code and links in text become inline elements.
+func transformMarkdownSyntax(doc *goquery.Document, ctx *TransformContext) error {
+	for _, note := range doc.Find("en-note").Nodes {
+		markdownBlocks(note)
+		markdownInlines(note)
+	}
+	return nil
+}
+
+const (
+	lineText  = ""
+	lineBlank = "blank"
+	lineQuote = "blockquote"
+	lineUL    = "ul"
+	lineOL    = "ol"
+	lineHR    = "hr"
+	lineTable = "table"
+)
+
+var (
+	markdownQuoteLine = regexp.MustCompile(`^>\s?`)
+	markdownULLine    = regexp.MustCompile(`^[-*+]\s+`)
+	markdownOLLine    = regexp.MustCompile(`^\d+[.)]\s+`)
+	markdownHRLine    = regexp.MustCompile(`^(-\s*){3,}$|^(\*\s*){3,}$|^(_\s*){3,}$`)
+	markdownTableLine = regexp.MustCompile(`^\|.*\|$`)
+	markdownTableRule = regexp.MustCompile(`^\|(\s*:?-+:?\s*\|)+$`)
+)
+
+// isLine reports whether n is a div which is a line of the note
+func isLine(n *nethtml.Node) bool {
+	return n.Type == nethtml.ElementNode && n.Data == "div" && !hasBlockChild(n)
+}
+
+func lineKind(n *nethtml.Node) (string, string) {
+	text := strings.TrimSpace(strings.Replace(textContent(n), "\u00a0", " ", -1))
+	switch {
+	case text == "":
+		return lineBlank, ""
+	case markdownHRLine.MatchString(text):
+		return lineHR, ""
+	case markdownQuoteLine.MatchString(text):
+		return lineQuote, markdownQuoteLine.FindString(text)
+	case markdownULLine.MatchString(text):
+		return lineUL, markdownULLine.FindString(text)
+	case markdownOLLine.MatchString(text):
+		return lineOL, markdownOLLine.FindString(text)
+	case markdownTableLine.MatchString(text):
+		return lineTable, ""
+	}
+	return lineText, ""
+}
+
+// markdownBlocks groups consecutive lines of the same kind under parent into blocks
+func markdownBlocks(parent *nethtml.Node) {
+	var group []*nethtml.Node
+	var markers []string
+	kind := lineText
+
+	flush := func() {
+		if len(group) > 0 {
+			replaceLines(kind, group, markers)
+		}
+		group, markers, kind = nil, nil, lineText
+	}
+
+	for c := parent.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == nethtml.TextNode && strings.TrimSpace(c.Data) == "":
+		case isLine(c):
+			k, marker := lineKind(c)
+			if k != kind {
+				flush()
+			}
+			if k == lineHR {
+				replaceLines(k, []*nethtml.Node{c}, nil)
+			} else if k != lineText && k != lineBlank {
+				kind = k
+				group = append(group, c)
+				markers = append(markers, marker)
+			}
+		case c.Type == nethtml.ElementNode && (c.Data == "div" || c.Data == "blockquote"):
+			flush()
+			markdownBlocks(c)
+		default:
+			flush()
+		}
+		c = next
+	}
+	flush()
+}
+
+func newNode(tag string) *nethtml.Node {
+	return &nethtml.Node{Type: nethtml.ElementNode, Data: tag, DataAtom: atom.Lookup([]byte(tag))}
+}
+
+// replaceLines replaces a group of lines with a block element
+func replaceLines(kind string, lines []*nethtml.Node, markers []string) {
+	var block *nethtml.Node
+	switch kind {
+	case lineHR:
+		block = newNode("hr")
+	case lineQuote:
+		block = newNode("blockquote")
+	case lineUL, lineOL:
+		block = newNode(kind)
+		// lists keep the number of their first items
+		if start, _ := strconv.Atoi(strings.TrimRight(markers[0], ".) \t")); kind == lineOL && start != 1 {
+			block.Attr = []nethtml.Attribute{{Key: "start", Val: strconv.Itoa(start)}}
+		}
+	case lineTable:
+		if block = pipeTable(lines); block == nil {
+			return
+		}
+	}
+
+	parent := lines[0].Parent
+	parent.InsertBefore(block, lines[0])
+	for i, line := range lines {
+		parent.RemoveChild(line)
+		switch kind {
+		case lineQuote:
+			trimLeadingText(line, markers[i])
+			block.AppendChild(line)
+		case lineUL, lineOL:
+			trimLeadingText(line, markers[i])
+			li := newNode("li")
+			for line.FirstChild != nil {
+				child := line.FirstChild
+				line.RemoveChild(child)
+				li.AppendChild(child)
+			}
+			block.AppendChild(li)
+		}
+	}
+	// quotes can have lists and so on
+	if kind == lineQuote {
+		markdownBlocks(block)
+	}
+}
+
+// pipeTable makes a table from lines like "| a | b |", whose second line is a rule like "| --- | --- |".
+// It returns nil if the lines aren't a table.
+func pipeTable(lines []*nethtml.Node) *nethtml.Node {
+	if len(lines) < 2 {
+		return nil
+	}
+	var rows [][]string
+	for _, line := range lines {
+		text := strings.TrimSpace(strings.Replace(textContent(line), "\u00a0", " ", -1))
+		rows = append(rows, splitTableRow(text))
+	}
+	rule := strings.Replace(strings.TrimSpace(strings.Replace(textContent(lines[1]), "\u00a0", " ", -1)), " ", "", -1)
+	if !markdownTableRule.MatchString(rule) {
+		return nil
+	}
+
+	table := newNode("table")
+	thead := newNode("thead")
+	tbody := newNode("tbody")
+	table.AppendChild(thead)
+	table.AppendChild(tbody)
+	for i, row := range rows {
+		if i == 1 {
+			continue
+		}
+		tr := newNode("tr")
+		cellTag := "td"
+		if i == 0 {
+			cellTag = "th"
+			thead.AppendChild(tr)
+		} else {
+			tbody.AppendChild(tr)
+		}
+		for _, cell := range row {
+			td := newNode(cellTag)
+			td.AppendChild(&nethtml.Node{Type: nethtml.TextNode, Data: cell})
+			tr.AppendChild(td)
+		}
+	}
+	if tbody.FirstChild == nil {
+		table.RemoveChild(tbody)
+	}
+	return table
+}
+
+// splitTableRow splits a row by pipes which aren't escaped
+func splitTableRow(row string) []string {
+	row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(row); i++ {
+		switch {
+		case row[i] == '\\' && i+1 < len(row) && row[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case row[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(row[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// trimLeadingText removes prefix, which is made of text, from the beginning of n
+func trimLeadingText(n *nethtml.Node, prefix string) {
+	var walk func(n *nethtml.Node) bool
+	walk = func(n *nethtml.Node) bool {
+		for c := n.FirstChild; c != nil && prefix != ""; c = c.NextSibling {
+			if c.Type == nethtml.TextNode {
+				text := strings.TrimLeft(strings.Replace(c.Data, "\u00a0", " ", -1), " \t\r\n")
+				if len(text) >= len(prefix) {
+					c.Data = text[len(prefix):]
+					prefix = ""
+					return true
+				}
+				prefix = prefix[len(text):]
+				c.Data = ""
+				continue
+			}
+			if walk(c) {
+				return true
+			}
+		}
+		return prefix == ""
+	}
+	walk(n)
+}
+
+// markdownInlineSkipped are elements whose text is left as it is
+var markdownInlineSkipped = map[string]bool{
+	"pre": true, "code": true, "a": true, "script": true, "style": true, "en-crypt": true, rawElement: true,
+}
+
+// markdownInlines converts inline markdown in text nodes under n
+func markdownInlines(n *nethtml.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case nethtml.ElementNode:
+			if !markdownInlineSkipped[c.Data] {
+				markdownInlines(c)
+			}
+		case nethtml.TextNode:
+			if converted, ok := inlineMarkdown(c.Data); ok {
+				nodes, err := nethtml.ParseFragment(strings.NewReader(converted), &nethtml.Node{Type: nethtml.ElementNode, Data: "div", DataAtom: atom.Div})
+				if err == nil {
+					for _, node := range nodes {
+						n.InsertBefore(node, c)
+					}
+					n.RemoveChild(c)
+				}
+			}
+		}
+		c = next
+	}
+}
+
+var markdownInlinePatterns = []struct {
+	pattern *regexp.Regexp
+	tag     string
+}{
+	{regexp.MustCompile("`([^`]+)`"), "code"},
+	// destinations of links are read by linkDestination
+	{regexp.MustCompile(`\[([^\]]+)\]\(`), "a"},
+	{regexp.MustCompile(`\*\*([^*\s](?:[^*]*[^*\s])?)\*\*`), "strong"},
+	{regexp.MustCompile(`(?:^|\W)(__([^_\s](?:[^_]*[^_\s])?)__)(?:$|\W)`), "strong"},
+	{regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*`), "em"},
+	// underscores in words like snake_case aren't emphasis
+	{regexp.MustCompile(`(?:^|\W)(_([^_\s](?:[^_]*[^_\s])?)_)(?:$|\W)`), "em"},
+	{regexp.MustCompile(`~~([^~\s](?:[^~]*[^~\s])?)~~`), "del"},
+}
+
+var markdownInlineEscape = regexp.MustCompile("\\\\([\\\\`*_\\[\\]()~>#|-])")
+
+// inlineMarkdown returns html of text with inline markdown converted, and whether anything is converted.
+// Backslashes escape markdown characters.
+func inlineMarkdown(text string) (string, bool) {
+	// escaped characters are hidden from patterns in the private use area
+	hidden := markdownInlineEscape.ReplaceAllStringFunc(text, func(escaped string) string {
+		return string(rune(0xE000 + int(escaped[1])))
+	})
+	converted, changed := inlineMarkdownHTML(hidden)
+	if !changed && hidden == text {
+		return "", false
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 0xE000 && r < 0xE080 {
+			return r - 0xE000
+		}
+		return r
+	}, converted), true
+}
+
+func inlineMarkdownHTML(text string) (string, bool) {
+	var buf strings.Builder
+	changed := false
+	for text != "" {
+		// the earliest match wins
+		start, end, tag := -1, -1, ""
+		var groups []int
+		for _, p := range markdownInlinePatterns {
+			m := p.pattern.FindStringSubmatchIndex(text)
+			if p.tag == "a" {
+				m = markdownLink(p.pattern, text)
+			}
+			if m == nil {
+				continue
+			}
+			// patterns with word boundaries have the markup in the first group
+			s, e := m[0], m[1]
+			if p.pattern.NumSubexp() == 2 && p.tag != "a" {
+				s, e = m[2], m[3]
+				m = []int{s, e, m[4], m[5]}
+			}
+			if start < 0 || s < start {
+				start, end, tag, groups = s, e, p.tag, m
+			}
+		}
+		if start < 0 {
+			buf.WriteString(html.EscapeString(text))
+			break
+		}
+
+		changed = true
+		buf.WriteString(html.EscapeString(text[:start]))
+		inner := text[groups[2]:groups[3]]
+		switch tag {
+		case "code":
+			buf.WriteString("<code>" + html.EscapeString(inner) + "</code>")
+		case "a":
+			innerHTML, _ := inlineMarkdownHTML(inner)
+			buf.WriteString(`<a href="` + html.EscapeString(text[groups[4]:groups[5]]) + `">` + innerHTML + "</a>")
+		default:
+			innerHTML, _ := inlineMarkdownHTML(inner)
+			buf.WriteString("<" + tag + ">" + innerHTML + "</" + tag + ">")
+		}
+		text = text[end:]
+	}
+	return buf.String(), changed
+}
+
+// markdownLink returns indexes of the first link in text like FindStringSubmatchIndex,
+// whose groups are the text and the destination of the link
+func markdownLink(start *regexp.Regexp, text string) []int {
+	for _, m := range start.FindAllStringSubmatchIndex(text, -1) {
+		if destStart, destEnd, end, ok := linkDestination(text, m[1]); ok {
+			return []int{m[0], end, m[2], m[3], destStart, destEnd}
+		}
+	}
+	return nil
+}
+
+// linkDestination reads the destination of a link at i followed by ")", which is wrapped in <> or has balanced parentheses
+// like CommonMark. It returns the range of the destination and the end of the link.
+func linkDestination(text string, i int) (int, int, int, bool) {
+	if strings.HasPrefix(text[i:], "<") {
+		j := strings.IndexAny(text[i+1:], "<>\n")
+		if j < 0 || text[i+1+j] != '>' || !strings.HasPrefix(text[i+1+j+1:], ")") {
+			return 0, 0, 0, false
+		}
+		return i + 1, i + 1 + j, i + 1 + j + 2, true
+	}
+
+	depth := 0
+	for j := i; j < len(text); j++ {
+		switch c := text[j]; {
+		case c <= ' ' || c == 0x7f:
+			return 0, 0, 0, false
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ')':
+			return i, j, j + 1, j > i
+		}
+	}
+	return 0, 0, 0, false
+}
@@ -0,0 +1,143 @@
+package convert
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// extractFrontMatter removes a yaml block at the top of the note and returns its fields.
+// The block is either a ```yaml fence or lines between "---".
+// "---" lines which aren't followed by a yaml mapping are left as they are.
+func extractFrontMatter(doc *goquery.Document) (yaml.MapSlice, error) {
+	container := doc.Find("en-note").First()
+	if len(container.Nodes) == 0 {
+		return nil, nil
+	}
+	// some notes are wrapped in a div as a whole
+	parent := container.Nodes[0]
+	for {
+		child := onlyElementChild(parent)
+		if child == nil || child.Data != "div" || !hasBlockChild(child) {
+			break
+		}
+		parent = child
+	}
+
+	var consumed []*html.Node
+	var lines []string
+	closing := ""
+	fenced := false
+	closed := false
+	for c := parent.FirstChild; c != nil && !closed; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+			consumed = append(consumed, c)
+			continue
+		}
+		if c.Type != html.ElementNode || (c.Data != "div" && c.Data != "p") || hasBlockChild(c) {
+			return nil, nil
+		}
+		consumed = append(consumed, c)
+
+		for _, line := range strings.Split(textWithBreaks(c), "\n") {
+			trimmed := strings.TrimSpace(line)
+			switch {
+			case closed:
+				// the block must end with the line
+				if trimmed != "" {
+					return nil, nil
+				}
+			case closing == "":
+				switch trimmed {
+				case "":
+				case "---":
+					closing = "---"
+				case "```yaml", "```yml":
+					closing = "```"
+					fenced = true
+				default:
+					return nil, nil
+				}
+			case trimmed == closing:
+				closed = true
+			default:
+				lines = append(lines, line)
+			}
+		}
+	}
+	// an unclosed fence is reported as a code block later
+	if !closed {
+		return nil, nil
+	}
+
+	var fields yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &fields); err != nil {
+		if fenced {
+			return nil, errors.Wrap(err, "can't parse yaml")
+		}
+		return nil, nil
+	}
+
+	for _, n := range consumed {
+		parent.RemoveChild(n)
+	}
+	return fields, nil
+}
+
+func onlyElementChild(n *html.Node) *html.Node {
+	var only *html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+			continue
+		}
+		if c.Type != html.ElementNode || only != nil {
+			return nil
+		}
+		only = c
+	}
+	return only
+}
+
+// textWithBreaks returns the text of n with <br> as line breaks
+func textWithBreaks(n *html.Node) string {
+	switch {
+	case n.Type == html.TextNode:
+		return strings.Replace(n.Data, "\u00a0", " ", -1)
+	case n.Type == html.ElementNode && n.Data == "br":
+		return "\n"
+	}
+	var text string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text += textWithBreaks(c)
+	}
+	return text
+}
+
+var frontMatterDateFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseFrontMatterDate parses a date in front matter of a note, in loc if the time zone isn't specified
+func parseFrontMatterDate(value interface{}, loc *time.Location) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.In(loc), nil
+	case string:
+		for _, layout := range frontMatterDateFormats {
+			if date, err := time.ParseInLocation(layout, v, loc); err == nil {
+				return date, nil
+			}
+		}
+	}
+	return time.Time{}, errors.Errorf("can't parse date %v", value)
+}
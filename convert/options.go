@@ -0,0 +1,178 @@
+package convert
+
+import (
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	resourceLayoutFlat    = "flat"
+	resourceLayoutPerPost = "per_post"
+)
+
+const (
+	formatHTML     = "html"
+	formatMarkdown = "markdown"
+)
+
+const (
+	dateFromSubject = "subject"
+	dateFromCreated = "created"
+	dateFromUpdated = "updated"
+)
+
+const (
+	draftsFrontMatter = "front_matter"
+	draftsDir         = "drafts_dir"
+	draftsSkip        = "skip"
+)
+
+const (
+	redirectFrontMatter = "front_matter"
+	redirectStub        = "stub"
+	redirectNone        = "none"
+)
+
+// Options are convert settings in the configuration file
+type Options struct {
+	// Target is the preset of the static site generator to write posts for, "jekyll" or "hugo"
+	Target string `yaml:"target,omitempty"`
+
+	// TargetDefinition overrides the preset, e.g. for eleventy or zola
+	TargetDefinition TargetDefinition `yaml:"target_definition,omitempty"`
+
+	// ResourceLayout is "flat" to copy resources into the resources directory,
+	// or "per_post" to copy them into a subdirectory named after each post.
+	// Hugo target always copies them into the page bundle.
+	ResourceLayout string `yaml:"resource_layout,omitempty"`
+
+	// Format is "html" or "markdown" for posts to be written
+	Format string `yaml:"format,omitempty"`
+
+	// HighlightTags uses the code block template of the target in markdown instead of fenced code blocks
+	HighlightTags bool `yaml:"highlight_tags,omitempty"`
+
+	// Highlight highlights code blocks while converting instead of leaving them to the target
+	Highlight HighlightOptions `yaml:"highlight,omitempty"`
+
+	// TableHeader makes the first row of tables the header
+	TableHeader bool `yaml:"table_header,omitempty"`
+
+	// Slug configures slugs of posts
+	Slug SlugOptions `yaml:"slug,omitempty"`
+
+	// Tags configure special tags and tags written as front matter
+	Tags TagOptions `yaml:"tags,omitempty"`
+
+	// Attributes maps attributes of notes to front matter keys, e.g. {author: author, location: location}
+	Attributes map[string]string `yaml:"attributes,omitempty"`
+
+	// Timezone is the time zone of dates in posts like "Asia/Tokyo", the local time zone by default
+	Timezone string `yaml:"timezone,omitempty"`
+
+	// DateFrom is the timestamp of notes used as dates of posts, which are also used in their paths.
+	// "subject" uses the subject date if it is set and the created date otherwise, "created" or "updated".
+	DateFrom string `yaml:"date_from,omitempty"`
+
+	// Drafts is how unpublished posts are written.
+	// "drafts_dir" (default) writes them into the drafts directory of the target, "front_matter" writes them
+	// as posts which aren't published, and "skip" doesn't write unpublished posts and pages.
+	Drafts string `yaml:"drafts,omitempty"`
+
+	// ScheduleByReminder publishes notes at their reminder time, like publish_at in front matter of notes
+	ScheduleByReminder bool `yaml:"schedule_by_reminder,omitempty"`
+
+	// Transforms are names of transformers applied to notes in order, which can be registered by RegisterTransformer.
+	// web_clip, decrypt, todo, code_fence, code_block, heading, markdown, table, media and sanitize by default.
+	Transforms []string `yaml:"transforms,omitempty"`
+
+	// Encryption configures how encrypted sections of notes are decrypted
+	Encryption EncryptionOptions `yaml:"encryption,omitempty"`
+
+	// WebClips configure notes clipped from web pages
+	WebClips WebClipOptions `yaml:"web_clips,omitempty"`
+
+	// Sanitize configures elements and attributes kept by the sanitize transform
+	Sanitize SanitizeOptions `yaml:"sanitize,omitempty"`
+
+	// location is loaded from Timezone by validate
+	location *time.Location
+
+	// Redirects is how old urls of posts are redirected when they move.
+	// "front_matter" lists them in the front matter, "stub" writes html files which redirect to the post,
+	// and "none" doesn't redirect them.
+	Redirects string `yaml:"redirects,omitempty"`
+}
+
+func (opts *Options) validate() error {
+	switch opts.ResourceLayout {
+	case "", resourceLayoutFlat, resourceLayoutPerPost:
+	default:
+		return errors.Errorf("unknown resource layout %v", opts.ResourceLayout)
+	}
+	switch opts.Format {
+	case "", formatHTML, formatMarkdown:
+	default:
+		return errors.Errorf("unknown format %v", opts.Format)
+	}
+	switch opts.Redirects {
+	case "", redirectFrontMatter, redirectStub, redirectNone:
+	default:
+		return errors.Errorf("unknown redirects %v", opts.Redirects)
+	}
+	switch opts.Drafts {
+	case "", draftsFrontMatter, draftsDir, draftsSkip:
+	default:
+		return errors.Errorf("unknown drafts %v", opts.Drafts)
+	}
+	switch opts.DateFrom {
+	case "", dateFromSubject, dateFromCreated, dateFromUpdated:
+	default:
+		return errors.Errorf("unknown date_from %v", opts.DateFrom)
+	}
+	location, err := time.LoadLocation(opts.Timezone)
+	if err != nil {
+		return errors.Wrapf(err, "unknown timezone %v", opts.Timezone)
+	}
+	if opts.Timezone == "" {
+		location = time.Local
+	}
+	opts.location = location
+
+	if err := validateAttributes(opts.Attributes); err != nil {
+		return err
+	}
+	if err := opts.Encryption.validate(); err != nil {
+		return err
+	}
+	if err := opts.WebClips.validate(); err != nil {
+		return err
+	}
+	if err := opts.Sanitize.validate(); err != nil {
+		return err
+	}
+	if err := opts.Highlight.validate(); err != nil {
+		return err
+	}
+	if err := opts.Tags.validate(); err != nil {
+		return err
+	}
+	return opts.Slug.validate()
+}
+
+// hash returns a hash of the options to find notes which need to be converted again.
+// The passphrase is left out, as the hash is written in the manifest and could be used to guess it.
+func (opts *Options) hash() (string, error) {
+	hashed := *opts
+	if hashed.Encryption.Passphrase != "" {
+		hashed.Encryption.Passphrase = "set"
+	}
+	optsBytes, err := yaml.Marshal(&hashed)
+	if err != nil {
+		return "", errors.Wrap(err, "can't marshal convert options")
+	}
+	return hashConfig(string(optsBytes)), nil
+}
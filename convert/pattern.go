@@ -0,0 +1,27 @@
+package convert
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var patternVariable = regexp.MustCompile(`\{\{([a-z_]+)\}\}`)
+
+// expandPattern replaces {{name}} in pattern with vars.
+// Other braces, like liquid tags or hugo shortcodes, are left as they are.
+func expandPattern(pattern string, vars map[string]string) (string, error) {
+	var unknown string
+	expanded := patternVariable.ReplaceAllStringFunc(pattern, func(variable string) string {
+		name := patternVariable.FindStringSubmatch(variable)[1]
+		value, ok := vars[name]
+		if !ok && unknown == "" {
+			unknown = name
+		}
+		return value
+	})
+	if unknown != "" {
+		return "", errors.Errorf("unknown variable {{%v}} in %v", unknown, pattern)
+	}
+	return expanded, nil
+}
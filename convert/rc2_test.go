@@ -0,0 +1,120 @@
+package convert
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// test vectors of RFC 2268
+var rc2Vectors = []struct {
+	key        string
+	bits       int
+	plaintext  string
+	ciphertext string
+}{
+	{"0000000000000000", 63, "0000000000000000", "ebb773f993278eff"},
+	{"ffffffffffffffff", 64, "ffffffffffffffff", "278b27e42e2f0d49"},
+	{"3000000000000000", 64, "1000000000000001", "30649edf9be7d2c2"},
+	{"88", 64, "0000000000000000", "61a8a244adacccf0"},
+	{"88bca90e90875a", 64, "0000000000000000", "6ccf4308974c267f"},
+	{"88bca90e90875a7f0f79c384627bafb2", 64, "0000000000000000", "1a807d272bbe5db1"},
+	{"88bca90e90875a7f0f79c384627bafb2", 128, "0000000000000000", "2269552ab0f85ca6"},
+	{"88bca90e90875a7f0f79c384627bafb216f80a6f85920584c42fceb0be255daf1e", 129, "0000000000000000", "5b78d3a43dfff1f1"},
+}
+
+func TestRC2Decrypt(t *testing.T) {
+	for _, v := range rc2Vectors {
+		key, _ := hex.DecodeString(v.key)
+		ciphertext, _ := hex.DecodeString(v.ciphertext)
+		decrypted, err := rc2Decrypt(key, v.bits, ciphertext)
+		if err != nil {
+			t.Fatalf("key %v: %v", v.key, err)
+		}
+		if got := hex.EncodeToString(decrypted); got != v.plaintext {
+			t.Errorf("key %v, %v bits: got %v, want %v", v.key, v.bits, got, v.plaintext)
+		}
+	}
+}
+
+func TestRC2Text(t *testing.T) {
+	text := []byte("secret\x00\x00")
+	checksum := fmt.Sprintf("%08X", crc32.ChecksumIEEE(text))[:4]
+
+	got, err := rc2Text(append([]byte(checksum), text...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "secret" {
+		t.Errorf("got %q, want %q", got, "secret")
+	}
+
+	if _, err := rc2Text(append([]byte("0000"), text...)); err == nil && checksum != "0000" {
+		t.Error("wrong checksum is accepted")
+	}
+	if _, err := rc2Text([]byte("AB")); err == nil {
+		t.Error("too short section is accepted")
+	}
+}
+
+// encryptAES encrypts html in the ENC0 layout of Evernote, the reverse of decryptAES
+func encryptAES(t *testing.T, plaintext string, passphrase string, bits int) []byte {
+	salt := bytes.Repeat([]byte{1}, 16)
+	hmacSalt := bytes.Repeat([]byte{2}, 16)
+	iv := bytes.Repeat([]byte{3}, 16)
+
+	key := pbkdf2.Key([]byte(passphrase), salt, aesIterations, bits/8, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append([]byte(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	body := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(body, padded)
+
+	data := append([]byte("ENC0"), salt...)
+	data = append(data, hmacSalt...)
+	data = append(data, iv...)
+	data = append(data, body...)
+	h := hmac.New(sha256.New, pbkdf2.Key([]byte(passphrase), hmacSalt, aesIterations, bits/8, sha256.New))
+	h.Write(data)
+	return append(data, h.Sum(nil)...)
+}
+
+func TestDecryptAES(t *testing.T) {
+	// a block of padding is added to plaintext of 16 bytes
+	for _, plaintext := range []string{`<div>buy <b>milk</b></div>`, "0123456789abcdef"} {
+		data := encryptAES(t, plaintext, "passphrase", 128)
+
+		got, err := decryptSection("AES", "128", base64.StdEncoding.EncodeToString(data), "passphrase")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != plaintext {
+			t.Errorf("got %q, want %q", got, plaintext)
+		}
+
+		if _, err := decryptAES(data, "wrong", 128); err == nil {
+			t.Error("wrong passphrase is accepted")
+		}
+
+		tampered := append([]byte{}, data...)
+		tampered[len(tampered)-40] ^= 1
+		if _, err := decryptAES(tampered, "passphrase", 128); err == nil {
+			t.Error("tampered section is accepted")
+		}
+		if _, err := decryptAES(data[:40], "passphrase", 128); err == nil {
+			t.Error("truncated section is accepted")
+		}
+	}
+}
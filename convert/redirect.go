@@ -0,0 +1,26 @@
+package convert
+
+import (
+	"html"
+	"strings"
+)
+
+const redirectStubTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Redirecting&hellip;</title>
+<link rel="canonical" href="{{url}}">
+<meta http-equiv="refresh" content="0; url={{url}}">
+<meta name="robots" content="noindex">
+</head>
+<body>
+<a href="{{url}}">{{url}}</a>
+</body>
+</html>
+`
+
+// redirectStubHTML returns an html file which redirects to url
+func redirectStubHTML(url string) []byte {
+	return []byte(strings.Replace(redirectStubTemplate, "{{url}}", html.EscapeString(url), -1))
+}
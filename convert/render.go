@@ -0,0 +1,84 @@
+package convert
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/yosssi/gohtml"
+)
+
+// rawElement holds markup which is written to posts as it is, e.g. liquid tags or hugo shortcodes.
+// Renderers would escape or reformat it if it were a text node.
+const rawElement = "chienote-raw"
+
+func replaceWithRaw(sel *goquery.Selection, raw string) {
+	sel.ReplaceWithHtml(`<` + rawElement + ` data-raw="` + html.EscapeString(raw) + `"></` + rawElement + `>`)
+}
+
+// replaceWithRawBlock is replaceWithRaw for markup which must be on its own lines like code blocks
+func replaceWithRawBlock(sel *goquery.Selection, raw string) {
+	sel.ReplaceWithHtml(`<` + rawElement + ` data-raw="` + html.EscapeString(raw) + `" data-block="true"></` + rawElement + `>`)
+}
+
+// renderHTML renders the note body as html with codeBlock, which is a code block template of the target or a highlighter
+func renderHTML(doc *goquery.Document, codeBlock func(language string, code string) string) (*string, error) {
+	doc.Find("pre[data-lang]").Each(func(_ int, pre *goquery.Selection) {
+		language, _ := pre.Attr("data-lang")
+		code := pre.Text()
+		if block := codeBlock(language, code); block != "" {
+			replaceWithRawBlock(pre, block)
+		} else {
+			replaceWithRawBlock(pre, `<pre><code class="language-`+html.EscapeString(language)+`">`+html.EscapeString(code)+`</code></pre>`)
+		}
+	})
+
+	// raw markups are put back after formatting so that they are kept as they are.
+	// Their tokens have a random nonce so that text of notes is never taken for them.
+	nonce, err := rawNonce()
+	if err != nil {
+		return nil, err
+	}
+	rawToken := regexp.MustCompile(`<div class="chienote-raw-block">\s*chienote-raw-` + nonce + `-(\d+)\s*</div>|chienote-raw-` + nonce + `-(\d+)`)
+	var raws []string
+	doc.Find(rawElement).Each(func(_ int, sel *goquery.Selection) {
+		raw, _ := sel.Attr("data-raw")
+		if _, block := sel.Attr("data-block"); block {
+			// formatting puts a div on its own lines
+			sel.ReplaceWithHtml(fmt.Sprintf(`<div class="chienote-raw-block">chienote-raw-%v-%d</div>`, nonce, len(raws)))
+		} else {
+			sel.ReplaceWithHtml(fmt.Sprintf("chienote-raw-%v-%d", nonce, len(raws)))
+		}
+		raws = append(raws, raw)
+	})
+
+	innerNoteHTML, err := doc.Find("en-note").Html()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't render html")
+	}
+	innerNoteHTML = strings.Replace(innerNoteHTML, "\u00a0", " ", -1)
+	innerNoteHTML = gohtml.Format(innerNoteHTML)
+	innerNoteHTML = rawToken.ReplaceAllStringFunc(innerNoteHTML, func(token string) string {
+		match := rawToken.FindStringSubmatch(token)
+		i, err := strconv.Atoi(match[1] + match[2])
+		if err != nil || i >= len(raws) {
+			return token
+		}
+		return raws[i]
+	})
+	return &innerNoteHTML, nil
+}
+
+func rawNonce() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't make raw token")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,298 @@
+package convert
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	sanitizeStylesTags    = "tags"
+	sanitizeStylesClasses = "classes"
+)
+
+// SanitizeOptions configure the sanitize transform, which removes elements, attributes and styles which aren't allowed
+type SanitizeOptions struct {
+	// Elements are allowed elements in addition to the default ones
+	Elements []string `yaml:"elements,omitempty"`
+
+	// Attributes are allowed attributes of elements in addition to the default ones, like {img: [loading]}.
+	// Attributes of "*" are allowed for every element.
+	Attributes map[string][]string `yaml:"attributes,omitempty"`
+
+	// Styles is "tags" (default) to write bold, italic, strikethrough, underline and highlight in styles
+	// as <strong>, <em>, <del>, <u> and <mark>, or "classes" to write them as spans with classes of their names
+	Styles string `yaml:"styles,omitempty"`
+}
+
+func (opts *SanitizeOptions) validate() error {
+	switch opts.Styles {
+	case "", sanitizeStylesTags, sanitizeStylesClasses:
+	default:
+		return errors.Errorf("unknown sanitize styles %v", opts.Styles)
+	}
+	return nil
+}
+
+// sanitizeElements are elements kept by the sanitize transform. The others are replaced with their contents.
+var sanitizeElements = map[string]bool{
+	"p": true, "div": true, "br": true, "hr": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true, "blockquote": true, "pre": true, "code": true,
+	"table": true, "caption": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "th": true, "td": true,
+	"a": true, "img": true, "audio": true, "video": true, "source": true, "figure": true, "figcaption": true,
+	"strong": true, "b": true, "em": true, "i": true, "u": true, "s": true, "del": true, "strike": true, "ins": true,
+	"sub": true, "sup": true, "mark": true, "small": true, "abbr": true, "cite": true, "q": true, "kbd": true,
+	"samp": true, "var": true, "time": true, "input": true, "details": true, "summary": true,
+	"en-note": true, "en-media": true, "en-todo": true, "en-crypt": true, rawElement: true,
+}
+
+// sanitizeAttributes are attributes kept by the sanitize transform for each element
+var sanitizeAttributes = map[string][]string{
+	"a":        {"href", "title"},
+	"img":      {"src", "alt", "title", "width", "height"},
+	"audio":    {"src", "controls"},
+	"video":    {"src", "controls", "width", "height"},
+	"source":   {"src", "type"},
+	"td":       {"colspan", "rowspan"},
+	"th":       {"colspan", "rowspan"},
+	"ol":       {"start"},
+	"abbr":     {"title"},
+	"time":     {"datetime"},
+	"input":    {"type", "checked", "disabled"},
+	"pre":      {"data-lang"},
+	"en-media": {"hash", "type", "width", "height", "alt"},
+	"en-todo":  {"checked"},
+	"en-crypt": {"hint", "cipher", "length"},
+	rawElement: {"data-raw", "data-block"},
+}
+
+// sanitizeRemovedElements are removed with their contents
+var sanitizeRemovedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true, "applet": true,
+	"noscript": true, "template": true, "frame": true, "frameset": true, "select": true, "textarea": true, "button": true,
+}
+
+// sanitizeURLAttributes are attributes whose values are urls, which mustn't run scripts
+var sanitizeURLAttributes = map[string]bool{"href": true, "src": true}
+
+// sanitizeURLSchemes are schemes of urls kept by the sanitize transform. Relative urls are always kept.
+var sanitizeURLSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+var (
+	urlScheme    = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+	dataImageURL = regexp.MustCompile(`(?i)^data:image/(png|gif|jpeg|webp|bmp);`)
+)
+
+// allowedURL reports whether u is relative or has an allowed scheme.
+// Whitespaces and control characters are removed first, as browsers ignore them in schemes like "java\tscript:".
+func allowedURL(u string) bool {
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	match := urlScheme.FindStringSubmatch(u)
+	if match == nil {
+		return true
+	}
+	return sanitizeURLSchemes[strings.ToLower(match[1])] || dataImageURL.MatchString(u)
+}
+
+// textStyle is a meaningful style, which is written as a tag or a class
+type textStyle struct {
+	tag   string
+	class string
+}
+
+var (
+	styleBold      = textStyle{"strong", "bold"}
+	styleItalic    = textStyle{"em", "italic"}
+	styleStrike    = textStyle{"del", "strikethrough"}
+	styleUnderline = textStyle{"u", "underline"}
+	styleHighlight = textStyle{"mark", "highlight"}
+)
+
+// transformSanitize removes elements and attributes which aren't allowed, and turns meaningful styles into tags or classes
+func transformSanitize(doc *goquery.Document, ctx *TransformContext) error {
+	s := newSanitizer(&ctx.Options.Sanitize)
+	for _, note := range doc.Find("en-note").Nodes {
+		s.sanitize(note)
+	}
+	return nil
+}
+
+type sanitizer struct {
+	elements   map[string]bool
+	attributes map[string]map[string]bool
+	classes    bool
+}
+
+func newSanitizer(opts *SanitizeOptions) *sanitizer {
+	s := &sanitizer{
+		elements:   map[string]bool{},
+		attributes: map[string]map[string]bool{},
+		classes:    opts.Styles == sanitizeStylesClasses,
+	}
+	for element := range sanitizeElements {
+		s.elements[element] = true
+	}
+	for _, element := range opts.Elements {
+		s.elements[element] = true
+	}
+	allow := func(element string, attributes []string) {
+		if s.attributes[element] == nil {
+			s.attributes[element] = map[string]bool{}
+		}
+		for _, attribute := range attributes {
+			s.attributes[element][attribute] = true
+		}
+	}
+	for element, attributes := range sanitizeAttributes {
+		allow(element, attributes)
+	}
+	for element, attributes := range opts.Attributes {
+		allow(element, attributes)
+	}
+	return s
+}
+
+// sanitize cleans children of n
+func (s *sanitizer) sanitize(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.ElementNode:
+			s.sanitizeElement(c)
+		case html.TextNode:
+		default:
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+func (s *sanitizer) sanitizeElement(n *html.Node) {
+	parent := n.Parent
+	if sanitizeRemovedElements[n.Data] || (n.Data == "input" && attr(n, "type") != "checkbox") {
+		parent.RemoveChild(n)
+		return
+	}
+	s.sanitize(n)
+
+	styles := textStyles(attr(n, "style"), !isBlock(n) && n.Data != "td" && n.Data != "th" && n.Data != "li")
+	var attrs []html.Attribute
+	for _, a := range n.Attr {
+		if !s.attributes[n.Data][a.Key] && !s.attributes["*"][a.Key] {
+			continue
+		}
+		if sanitizeURLAttributes[a.Key] && !allowedURL(a.Val) {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+	s.wrapChildren(n, styles)
+
+	if !s.elements[n.Data] {
+		for n.FirstChild != nil {
+			child := n.FirstChild
+			n.RemoveChild(child)
+			parent.InsertBefore(child, n)
+		}
+		parent.RemoveChild(n)
+	}
+}
+
+// wrapChildren wraps children of n with tags or a span with classes of styles
+func (s *sanitizer) wrapChildren(n *html.Node, styles []textStyle) {
+	if len(styles) == 0 || n.FirstChild == nil {
+		return
+	}
+
+	var outer, inner *html.Node
+	if s.classes {
+		classes := make([]string, len(styles))
+		for i, style := range styles {
+			classes[i] = style.class
+		}
+		outer = newNode("span")
+		outer.Attr = []html.Attribute{{Key: "class", Val: strings.Join(classes, " ")}}
+		inner = outer
+	} else {
+		for _, style := range styles {
+			wrapper := newNode(style.tag)
+			if outer == nil {
+				outer = wrapper
+			} else {
+				inner.AppendChild(wrapper)
+			}
+			inner = wrapper
+		}
+	}
+
+	for n.FirstChild != nil {
+		child := n.FirstChild
+		n.RemoveChild(child)
+		inner.AppendChild(child)
+	}
+	n.AppendChild(outer)
+}
+
+// textStyles returns meaningful styles in a style attribute.
+// Background colors are highlights only in inline elements, as blocks have them for layout.
+func textStyles(style string, inline bool) []textStyle {
+	var styles []textStyle
+	for _, declaration := range strings.Split(style, ";") {
+		kv := strings.SplitN(declaration, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		property := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(kv[1]), "!important")))
+
+		switch property {
+		case "font-weight":
+			if value == "bold" || value == "bolder" || value == "600" || value == "700" || value == "800" || value == "900" {
+				styles = append(styles, styleBold)
+			}
+		case "font-style":
+			if value == "italic" || value == "oblique" {
+				styles = append(styles, styleItalic)
+			}
+		case "text-decoration", "text-decoration-line":
+			if strings.Contains(value, "line-through") {
+				styles = append(styles, styleStrike)
+			}
+			if strings.Contains(value, "underline") {
+				styles = append(styles, styleUnderline)
+			}
+		case "-evernote-highlight", "--en-highlight":
+			if value != "false" && value != "" {
+				styles = appendStyle(styles, styleHighlight)
+			}
+		case "background-color", "background":
+			if !inline {
+				continue
+			}
+			switch value {
+			case "", "transparent", "inherit", "initial", "none", "white", "#fff", "#ffffff", "rgb(255, 255, 255)":
+			default:
+				styles = appendStyle(styles, styleHighlight)
+			}
+		}
+	}
+	return styles
+}
+
+func appendStyle(styles []textStyle, style textStyle) []textStyle {
+	for _, s := range styles {
+		if s == style {
+			return styles
+		}
+	}
+	return append(styles, style)
+}
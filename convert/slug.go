@@ -0,0 +1,287 @@
+package convert
+
+import (
+	"bytes"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	transliterateRomaji = "romaji"
+	transliteratePinyin = "pinyin"
+)
+
+const defaultSlugMaxLength = 80
+
+// SlugOptions configure slugs of posts, which are used in their file names and urls
+type SlugOptions struct {
+	// Pattern is expanded and then sanitized into the slug.
+	// The source url of the note is used if it is set, and the title otherwise by default.
+	// Web clips use the title, or the last segment of the source url if the title has no letters.
+	// variables: {{title}} {{source_url}} {{guid}} {{year}} {{month}} {{day}}
+	Pattern string `yaml:"pattern,omitempty"`
+
+	// Transliterate is a list of "romaji" for kana and "pinyin" for kanji
+	Transliterate []string `yaml:"transliterate,omitempty"`
+
+	// MaxLength is the maximum number of characters in the slug, 80 by default
+	MaxLength int `yaml:"max_length,omitempty"`
+}
+
+func (opts *SlugOptions) validate() error {
+	for _, name := range opts.Transliterate {
+		switch name {
+		case transliterateRomaji, transliteratePinyin:
+		default:
+			return errors.Errorf("unknown transliteration %v", name)
+		}
+	}
+	if opts.MaxLength < 0 {
+		return errors.Errorf("slug max length must not be negative")
+	}
+	if _, err := expandPattern(opts.Pattern, slugVars("", "", "", nil)); err != nil {
+		return errors.Wrap(err, "invalid slug pattern")
+	}
+	return nil
+}
+
+func (opts *SlugOptions) transliterates(name string) bool {
+	for _, t := range opts.Transliterate {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+func slugVars(title string, sourceURL string, guid string, post *Post) map[string]string {
+	vars := map[string]string{"title": title, "source_url": sourceURL, "guid": guid, "year": "", "month": "", "day": ""}
+	if post != nil {
+		vars["year"] = post.Date.Format("2006")
+		vars["month"] = post.Date.Format("01")
+		vars["day"] = post.Date.Format("02")
+	}
+	return vars
+}
+
+// slug returns a slug which is safe as a file name and a url.
+// It falls back to the guid if nothing is left after sanitizing.
+func (opts *SlugOptions) slug(title string, sourceURL string, guid string, post *Post) string {
+	webClip := post != nil && post.WebClip
+	pattern := opts.Pattern
+	if pattern == "" {
+		pattern = "{{title}}"
+		if sourceURL != "" && !webClip {
+			pattern = "{{source_url}}"
+		}
+	}
+	// the pattern is validated beforehand
+	expanded, _ := expandPattern(pattern, slugVars(title, sourceURL, guid, post))
+
+	if slug := opts.sanitize(expanded); slug != "" {
+		return slug
+	}
+	if opts.Pattern == "" && webClip {
+		if slug := opts.sanitize(lastURLSegment(sourceURL)); slug != "" {
+			return slug
+		}
+	}
+	return guid
+}
+
+// lastURLSegment returns the last segment of the path of rawURL without its extension, or the host if the path is empty
+func lastURLSegment(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	segment := path.Base(strings.TrimSuffix(u.Path, "/"))
+	segment = strings.TrimSuffix(segment, path.Ext(segment))
+	if segment == "" || segment == "." || segment == "/" {
+		return u.Host
+	}
+	return segment
+}
+
+// sanitize normalizes, transliterates and sanitizes s into a slug
+func (opts *SlugOptions) sanitize(s string) string {
+	s = norm.NFKC.String(s)
+	s = transliterate(s, opts.transliterates(transliterateRomaji), opts.transliterates(transliteratePinyin))
+
+	maxLength := opts.MaxLength
+	if maxLength == 0 {
+		maxLength = defaultSlugMaxLength
+	}
+	return sanitizeSlug(s, maxLength)
+}
+
+// sanitizeSlug lowercases s and joins runs of letters and numbers with hyphens
+func sanitizeSlug(s string, maxLength int) string {
+	var buf bytes.Buffer
+	separate := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r == '\'' || r == '’':
+			// "don't" becomes "dont" rather than "don-t"
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r):
+			if separate && buf.Len() > 0 {
+				buf.WriteByte('-')
+			}
+			separate = false
+			buf.WriteRune(r)
+		default:
+			separate = true
+		}
+	}
+
+	runes := []rune(buf.String())
+	if len(runes) <= maxLength {
+		return string(runes)
+	}
+
+	// cut at the end of a word if possible
+	cut := string(runes[:maxLength])
+	if runes[maxLength] != '-' {
+		if i := strings.LastIndex(cut, "-"); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.Trim(cut, "-")
+}
+
+// transliterate replaces runs of kana with romaji and kanji with pinyin, separated by spaces
+func transliterate(s string, romaji bool, toPinyin bool) string {
+	if !romaji && !toPinyin {
+		return s
+	}
+
+	pinyinArgs := pinyin.NewArgs()
+	runes := []rune(s)
+	var buf bytes.Buffer
+	for i := 0; i < len(runes); {
+		j := i
+		switch {
+		case romaji && isKana(runes[i]):
+			// hiragana and katakana are usually different words
+			for j < len(runes) && isKana(runes[j]) && (runes[j] == 'ー' || isKatakana(runes[j]) == isKatakana(runes[i])) {
+				j++
+			}
+			buf.WriteString(" " + kanaToRomaji(runes[i:j]) + " ")
+		case toPinyin && unicode.Is(unicode.Han, runes[i]):
+			for j < len(runes) && unicode.Is(unicode.Han, runes[j]) {
+				j++
+			}
+			buf.WriteString(" " + strings.Join(pinyin.LazyPinyin(string(runes[i:j]), pinyinArgs), " ") + " ")
+		default:
+			buf.WriteRune(runes[i])
+			j++
+		}
+		i = j
+	}
+	return buf.String()
+}
+
+func isKana(r rune) bool {
+	return unicode.In(r, unicode.Hiragana, unicode.Katakana) || r == 'ー'
+}
+
+func isKatakana(r rune) bool {
+	return unicode.Is(unicode.Katakana, r)
+}
+
+func toHiragana(r rune) rune {
+	if r >= 'ァ' && r <= 'ヶ' {
+		return r - 'ァ' + 'ぁ'
+	}
+	return r
+}
+
+// kanaToRomaji converts kana to romaji in the Hepburn style without long vowels
+func kanaToRomaji(kana []rune) string {
+	var buf bytes.Buffer
+	geminate := false
+	for i := 0; i < len(kana); i++ {
+		r := toHiragana(kana[i])
+		if r == 'っ' {
+			geminate = true
+			continue
+		}
+		if r == 'ー' {
+			continue
+		}
+
+		var syllable string
+		if i+1 < len(kana) {
+			if s, ok := romajiTable[string([]rune{r, toHiragana(kana[i+1])})]; ok {
+				syllable = s
+				i++
+			}
+		}
+		if syllable == "" {
+			s, ok := romajiTable[string(r)]
+			if !ok {
+				continue
+			}
+			syllable = s
+		}
+
+		if geminate && !strings.ContainsAny(syllable[:1], "aiueon") {
+			if strings.HasPrefix(syllable, "ch") {
+				buf.WriteByte('t')
+			} else {
+				buf.WriteByte(syllable[0])
+			}
+		}
+		geminate = false
+		buf.WriteString(syllable)
+	}
+	return buf.String()
+}
+
+var romajiTable = map[string]string{
+	"あ": "a", "い": "i", "う": "u", "え": "e", "お": "o",
+	"か": "ka", "き": "ki", "く": "ku", "け": "ke", "こ": "ko",
+	"さ": "sa", "し": "shi", "す": "su", "せ": "se", "そ": "so",
+	"た": "ta", "ち": "chi", "つ": "tsu", "て": "te", "と": "to",
+	"な": "na", "に": "ni", "ぬ": "nu", "ね": "ne", "の": "no",
+	"は": "ha", "ひ": "hi", "ふ": "fu", "へ": "he", "ほ": "ho",
+	"ま": "ma", "み": "mi", "む": "mu", "め": "me", "も": "mo",
+	"や": "ya", "ゆ": "yu", "よ": "yo",
+	"ら": "ra", "り": "ri", "る": "ru", "れ": "re", "ろ": "ro",
+	"わ": "wa", "ゐ": "i", "ゑ": "e", "を": "o", "ん": "n",
+	"が": "ga", "ぎ": "gi", "ぐ": "gu", "げ": "ge", "ご": "go",
+	"ざ": "za", "じ": "ji", "ず": "zu", "ぜ": "ze", "ぞ": "zo",
+	"だ": "da", "ぢ": "ji", "づ": "zu", "で": "de", "ど": "do",
+	"ば": "ba", "び": "bi", "ぶ": "bu", "べ": "be", "ぼ": "bo",
+	"ぱ": "pa", "ぴ": "pi", "ぷ": "pu", "ぺ": "pe", "ぽ": "po",
+	"ゔ": "vu",
+	"ぁ": "a", "ぃ": "i", "ぅ": "u", "ぇ": "e", "ぉ": "o",
+	"ゃ": "ya", "ゅ": "yu", "ょ": "yo", "ゎ": "wa", "ゕ": "ka", "ゖ": "ke",
+
+	"きゃ": "kya", "きゅ": "kyu", "きょ": "kyo",
+	"しゃ": "sha", "しゅ": "shu", "しょ": "sho", "しぇ": "she",
+	"ちゃ": "cha", "ちゅ": "chu", "ちょ": "cho", "ちぇ": "che",
+	"にゃ": "nya", "にゅ": "nyu", "にょ": "nyo",
+	"ひゃ": "hya", "ひゅ": "hyu", "ひょ": "hyo",
+	"みゃ": "mya", "みゅ": "myu", "みょ": "myo",
+	"りゃ": "rya", "りゅ": "ryu", "りょ": "ryo",
+	"ぎゃ": "gya", "ぎゅ": "gyu", "ぎょ": "gyo",
+	"じゃ": "ja", "じゅ": "ju", "じょ": "jo", "じぇ": "je",
+	"ぢゃ": "ja", "ぢゅ": "ju", "ぢょ": "jo",
+	"びゃ": "bya", "びゅ": "byu", "びょ": "byo",
+	"ぴゃ": "pya", "ぴゅ": "pyu", "ぴょ": "pyo",
+
+	// used in katakana for loanwords
+	"ふぁ": "fa", "ふぃ": "fi", "ふぇ": "fe", "ふぉ": "fo",
+	"てぃ": "ti", "でぃ": "di", "とぅ": "tu", "どぅ": "du",
+	"うぃ": "wi", "うぇ": "we", "うぉ": "wo",
+	"ゔぁ": "va", "ゔぃ": "vi", "ゔぇ": "ve", "ゔぉ": "vo",
+	"つぁ": "tsa", "つぃ": "tsi", "つぇ": "tse", "つぉ": "tso",
+	"いぇ": "ye",
+}
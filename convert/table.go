@@ -0,0 +1,159 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// tableCellAttributes are attributes of cells kept in tables, which mean something without styles
+var tableCellAttributes = map[string]bool{"colspan": true, "rowspan": true}
+
+// transformTables rewrites tables into <table>, <thead> and <tbody> without styles, colgroups and divs in cells.
+// The first row is the header if it is in <thead> or made of <th>, or the table_header option is set.
+func transformTables(doc *goquery.Document, ctx *TransformContext) error {
+	for _, table := range doc.Find("en-note table").Nodes {
+		cleanTable(table, ctx.Options.TableHeader)
+	}
+	return nil
+}
+
+func cleanTable(table *html.Node, firstRowHeader bool) {
+	var rows []*html.Node
+	header := firstRowHeader
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type != html.ElementNode:
+		case c.Data == "tr":
+			rows = append(rows, c)
+		case c.Data == "thead" || c.Data == "tbody" || c.Data == "tfoot":
+			for row := c.FirstChild; row != nil; row = row.NextSibling {
+				if row.Type == html.ElementNode && row.Data == "tr" {
+					if c.Data == "thead" && len(rows) == 0 {
+						header = true
+					}
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	if len(rows) > 0 && onlyHeaderCells(rows[0]) {
+		header = true
+	}
+
+	for table.FirstChild != nil {
+		table.RemoveChild(table.FirstChild)
+	}
+	table.Attr = nil
+	thead := newNode("thead")
+	tbody := newNode("tbody")
+
+	for i, row := range rows {
+		if row.Parent != nil {
+			row.Parent.RemoveChild(row)
+		}
+		row.Attr = nil
+		isHeader := header && i == 0
+		for cell := row.FirstChild; cell != nil; {
+			next := cell.NextSibling
+			if cell.Type != html.ElementNode || (cell.Data != "td" && cell.Data != "th") {
+				row.RemoveChild(cell)
+				cell = next
+				continue
+			}
+			if isHeader {
+				cell.Data, cell.DataAtom = "th", atom.Th
+			} else {
+				cell.Data, cell.DataAtom = "td", atom.Td
+			}
+			var attrs []html.Attribute
+			for _, a := range cell.Attr {
+				if tableCellAttributes[a.Key] {
+					attrs = append(attrs, a)
+				}
+			}
+			cell.Attr = attrs
+			unwrapLines(cell)
+			trimBreaks(cell)
+			cell = next
+		}
+
+		if isHeader {
+			thead.AppendChild(row)
+		} else {
+			tbody.AppendChild(row)
+		}
+	}
+
+	if thead.FirstChild != nil {
+		table.AppendChild(thead)
+	}
+	if tbody.FirstChild != nil {
+		table.AppendChild(tbody)
+	}
+}
+
+func onlyHeaderCells(row *html.Node) bool {
+	found := false
+	for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+		if cell.Type != html.ElementNode {
+			continue
+		}
+		if cell.Data != "th" {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// unwrapLines replaces lines in n, which are divs, with their contents separated by <br>
+func unwrapLines(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type != html.ElementNode || (c.Data != "div" && c.Data != "p") {
+			c = next
+			continue
+		}
+
+		unwrapLines(c)
+		// a line may end with <br>, which is the line break itself
+		if last := c.LastChild; last != nil && last.Type == html.ElementNode && last.Data == "br" {
+			c.RemoveChild(last)
+		}
+		if hasContentBefore(c) {
+			n.InsertBefore(newNode("br"), c)
+		}
+		for c.FirstChild != nil {
+			child := c.FirstChild
+			c.RemoveChild(child)
+			n.InsertBefore(child, c)
+		}
+		n.RemoveChild(c)
+		c = next
+	}
+}
+
+func hasContentBefore(n *html.Node) bool {
+	for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+		if c.Type == html.ElementNode || (c.Type == html.TextNode && strings.TrimSpace(c.Data) != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// trimBreaks removes <br> and spaces at the beginning and the end of n
+func trimBreaks(n *html.Node) {
+	isSpace := func(c *html.Node) bool {
+		return (c.Type == html.ElementNode && c.Data == "br") || (c.Type == html.TextNode && strings.TrimSpace(c.Data) == "")
+	}
+	for n.FirstChild != nil && isSpace(n.FirstChild) {
+		n.RemoveChild(n.FirstChild)
+	}
+	for n.LastChild != nil && isSpace(n.LastChild) {
+		n.RemoveChild(n.LastChild)
+	}
+}
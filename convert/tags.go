@@ -0,0 +1,164 @@
+package convert
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TagOptions configure how tags of notes are written to posts
+type TagOptions struct {
+	// Published and Page are tags which publish notes and make them pages, "published" and "page" by default
+	Published string `yaml:"published,omitempty"`
+	Page      string `yaml:"page,omitempty"`
+
+	// Rename maps tags to other names, e.g. to merge aliases into one tag
+	Rename map[string]string `yaml:"rename,omitempty"`
+
+	// Strip is a list of tags which aren't written to posts. They can contain "*".
+	Strip []string `yaml:"strip,omitempty"`
+
+	// FrontMatter maps tags to front matter fields. Matched tags aren't written as tags.
+	FrontMatter []TagRule `yaml:"front_matter,omitempty"`
+}
+
+// TagRule sets a front matter field of posts which have the tag
+type TagRule struct {
+	// Tag is a tag, which can contain "*" like "cat:*"
+	Tag string `yaml:"tag"`
+	// Field is a front matter key. "published" and "page" publish the note and make it a page.
+	Field string `yaml:"field"`
+	// Value is the value of the field, which is the part matched by "*" or true by default
+	Value interface{} `yaml:"value,omitempty"`
+	// List appends values to a list
+	List bool `yaml:"list,omitempty"`
+}
+
+func (opts *TagOptions) validate() error {
+	for _, rule := range opts.FrontMatter {
+		if rule.Tag == "" || rule.Field == "" {
+			return errors.Errorf("tag rules need both tag and field")
+		}
+		if strings.Count(rule.Tag, "*") > 1 {
+			return errors.Errorf("tag %v can't have more than one *", rule.Tag)
+		}
+		if rule.Field == "published" || rule.Field == "page" {
+			if _, ok := rule.Value.(bool); rule.Value != nil && !ok {
+				return errors.Errorf("value of %v must be true or false", rule.Field)
+			}
+			if rule.List {
+				return errors.Errorf("%v can't be a list", rule.Field)
+			}
+		}
+	}
+	for _, tag := range opts.Strip {
+		if strings.Count(tag, "*") > 1 {
+			return errors.Errorf("tag %v can't have more than one *", tag)
+		}
+	}
+	return nil
+}
+
+// matchTag matches tag with pattern which can contain one "*", and returns the part matched by "*"
+func matchTag(pattern string, tag string) (string, bool) {
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return "", pattern == tag
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	if len(tag) < len(prefix)+len(suffix) || !strings.HasPrefix(tag, prefix) || !strings.HasSuffix(tag, suffix) {
+		return "", false
+	}
+	return tag[len(prefix) : len(tag)-len(suffix)], true
+}
+
+// apply sets tags of the note to post, with special tags and rules applied
+func (opts *TagOptions) apply(post *Post, tags []string) {
+	publishedTag, pageTag := opts.Published, opts.Page
+	if publishedTag == "" {
+		publishedTag = "published"
+	}
+	if pageTag == "" {
+		pageTag = "page"
+	}
+
+	post.Tags = nil
+	written := map[string]bool{}
+	for _, tag := range tags {
+		if renamed, ok := opts.Rename[tag]; ok {
+			tag = renamed
+		}
+
+		switch tag {
+		case publishedTag:
+			post.Published = true
+			continue
+		case pageTag:
+			post.Page = true
+			continue
+		}
+		if opts.applyRules(post, tag) || opts.strips(tag) || written[tag] {
+			continue
+		}
+		written[tag] = true
+		post.Tags = append(post.Tags, tag)
+	}
+}
+
+// applyRules returns true if any rule matches tag
+func (opts *TagOptions) applyRules(post *Post, tag string) bool {
+	matched := false
+	for _, rule := range opts.FrontMatter {
+		captured, ok := matchTag(rule.Tag, tag)
+		if !ok {
+			continue
+		}
+		matched = true
+
+		value := rule.Value
+		if value == nil {
+			value = true
+			if strings.Contains(rule.Tag, "*") {
+				value = captured
+			}
+		}
+
+		switch rule.Field {
+		case "published":
+			post.Published = value.(bool)
+		case "page":
+			post.Page = value.(bool)
+		default:
+			if post.Fields == nil {
+				post.Fields = map[string]interface{}{}
+			}
+			if rule.List {
+				list, _ := post.Fields[rule.Field].([]interface{})
+				post.Fields[rule.Field] = append(list, value)
+			} else {
+				post.Fields[rule.Field] = value
+			}
+		}
+	}
+	return matched
+}
+
+func (opts *TagOptions) strips(tag string) bool {
+	for _, pattern := range opts.Strip {
+		if _, ok := matchTag(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedFieldKeys returns keys of additional front matter fields in a stable order
+func sortedFieldKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,505 @@
+package convert
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Post is a note to be written to the site
+type Post struct {
+	GUID      string
+	Title     string
+	Slug      string
+	Notebook  string
+	Date      time.Time
+	Updated   time.Time
+	Page      bool
+	Published bool
+	// Draft is an unpublished post written into the drafts directory
+	Draft     bool
+	Tags      []string
+	Extension string
+	// RedirectFrom is a list of urls the post was published at before
+	RedirectFrom []string
+	// SourceURL is the url of the page the note is clipped from or about
+	SourceURL string
+	// WebClip is true if the note is clipped from a web page
+	WebClip bool
+	// Fields are additional front matter fields, which override the others with the same keys
+	Fields map[string]interface{}
+}
+
+const (
+	mediaImage = "image"
+	mediaAudio = "audio"
+	mediaVideo = "video"
+	mediaFile  = "file"
+)
+
+// Target decides paths, urls and markups of posts for a static site generator
+type Target interface {
+	// PostPath returns the path of the post relative to the site root
+	PostPath(post *Post) string
+	// URL returns the url where the post is published
+	URL(post *Post) string
+	// RedirectPath returns the path of a redirect stub for url relative to the site root
+	RedirectPath(url string) string
+	// ResourceDir returns the directory relative to the site root, where resources of the post are copied
+	ResourceDir(post *Post) string
+	// ResourceURL returns the url of a resource file used in the post
+	ResourceURL(post *Post, fileName string) string
+	// FrontMatter returns the front matter block of the post including its delimiters
+	FrontMatter(post *Post) ([]byte, error)
+	// CodeBlock returns a template tag to highlight code, or "" to write a plain code block
+	CodeBlock(language string, code string) string
+	// Media returns the markup of an image, audio, video or file, or "" to write the default markup
+	Media(kind string, url string, name string) string
+}
+
+// TargetDefinition describes a static site generator.
+// Patterns and templates can contain {{variables}}, and blank fields are taken from the preset.
+type TargetDefinition struct {
+	// PostPath and PagePath are patterns of paths relative to the site root.
+	// variables: {{year}} {{month}} {{day}} {{slug}} {{notebook}} {{guid}} {{ext}}
+	// {{categories}} is categories of the post like jekyll's :categories, starting with "/" if there are any.
+	PostPath string `yaml:"post_path,omitempty"`
+	PagePath string `yaml:"page_path,omitempty"`
+
+	// DraftPath is a pattern of paths of drafts, which are written to PostPath if it is blank.
+	// variables: the same as PostPath
+	DraftPath string `yaml:"draft_path,omitempty"`
+
+	// Permalink and PagePermalink are patterns of the permalink front matter, which is omitted if blank.
+	// variables: the same as PostPath
+	Permalink     string `yaml:"permalink,omitempty"`
+	PagePermalink string `yaml:"page_permalink,omitempty"`
+
+	// PostURL and PageURL are patterns of urls where the site generator publishes posts and pages.
+	// They are used for redirects, and permalinks are used instead if they are set.
+	// variables: the same as PostPath
+	PostURL string `yaml:"post_url,omitempty"`
+	PageURL string `yaml:"page_url,omitempty"`
+
+	// RedirectDir is the directory relative to the site root where redirect stubs are written
+	RedirectDir string `yaml:"redirect_dir,omitempty"`
+
+	// ResourceDir is a pattern of the directory where resources of a post are copied.
+	// variables: the same as PostPath, and {{post_dir}} which is the directory of the post
+	ResourceDir string `yaml:"resource_dir,omitempty"`
+
+	// ResourceURL is a pattern of resource urls in posts, which is prefixed with URLPrefix if it starts with "/".
+	// variables: the same as PostPath, and {{resource_dir}} {{file}}
+	// URLPrefix can be set blank to override the preset.
+	ResourceURL string  `yaml:"resource_url,omitempty"`
+	URLPrefix   *string `yaml:"url_prefix,omitempty"`
+
+	FrontMatter FrontMatterDefinition `yaml:"front_matter,omitempty"`
+
+	// CodeBlock is a template of highlighted code blocks.
+	// It can be set blank to write plain code blocks.
+	// variables: {{lang}} {{code}}
+	CodeBlock *string `yaml:"code_block,omitempty"`
+
+	// Media are templates of attachments keyed by image, audio, video and file.
+	// variables: {{url}} {{name}}
+	Media map[string]string `yaml:"media,omitempty"`
+}
+
+// FrontMatterDefinition describes the format of front matters
+type FrontMatterDefinition struct {
+	// Format is yaml, toml or json
+	Format string `yaml:"format,omitempty"`
+	// Open and Close are delimiters of the front matter block
+	Open  string `yaml:"open,omitempty"`
+	Close string `yaml:"close,omitempty"`
+	// DateFormat is a layout of the time package, used in yaml and json
+	DateFormat string `yaml:"date_format,omitempty"`
+	// Fields maps title, layout, published, draft, date, last_modified, permalink, redirect_from and tags
+	// to front matter keys.
+	// Dotted keys make nested tables and a blank key omits the field. They are merged with the fields of the preset.
+	Fields map[string]string `yaml:"fields,omitempty"`
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+var frontMatterFieldOrder = []string{"title", "layout", "published", "draft", "date", "last_modified", "permalink", "redirect_from", "tags"}
+
+var jekyllPreset = TargetDefinition{
+	PostPath:    "_posts/{{year}}-{{month}}-{{day}}-{{slug}}{{ext}}",
+	PagePath:    "{{slug}}{{ext}}",
+	DraftPath:   "_drafts/{{slug}}{{ext}}",
+	PostURL:     "{{categories}}/{{year}}/{{month}}/{{day}}/{{slug}}.html",
+	PageURL:     "/{{slug}}.html",
+	ResourceDir: "resources",
+	ResourceURL: "/{{resource_dir}}/{{file}}",
+	URLPrefix:   stringPtr("{{ site.baseurl }}"),
+	FrontMatter: FrontMatterDefinition{
+		Format:     frontMatterYAML,
+		DateFormat: "2006-01-02 15:04:05 -0700",
+		Fields: map[string]string{
+			"title": "title", "layout": "layout", "published": "published", "date": "date", "last_modified": "last_modified_at",
+			"permalink": "permalink", "redirect_from": "redirect_from", "tags": "tags",
+		},
+	},
+	CodeBlock: stringPtr("{% highlight {{lang}} %}\n{{code}}\n{% endhighlight %}"),
+}
+
+var hugoPreset = TargetDefinition{
+	PostPath:    "content/posts/{{slug}}/index{{ext}}",
+	PagePath:    "content/{{slug}}/index{{ext}}",
+	PostURL:     "/posts/{{slug}}/",
+	PageURL:     "/{{slug}}/",
+	RedirectDir: "static",
+	ResourceDir: "{{post_dir}}",
+	ResourceURL: "{{file}}",
+	FrontMatter: FrontMatterDefinition{
+		Format:     frontMatterYAML,
+		DateFormat: time.RFC3339,
+		Fields: map[string]string{
+			"title": "title", "draft": "draft", "date": "date", "last_modified": "lastmod",
+			"permalink": "url", "redirect_from": "aliases", "tags": "tags",
+		},
+	},
+	CodeBlock: stringPtr("{{< highlight {{lang}} >}}\n{{code}}\n{{< /highlight >}}"),
+}
+
+// TargetFactory creates a Target from convert options
+type TargetFactory func(opts *Options) (Target, error)
+
+var targetFactories = map[string]TargetFactory{
+	"jekyll": func(opts *Options) (Target, error) {
+		preset := jekyllPreset
+		if opts.ResourceLayout == resourceLayoutPerPost {
+			preset.ResourceDir = "resources/{{slug}}"
+		}
+		return newTemplateTarget(preset, opts.TargetDefinition)
+	},
+	"hugo": func(opts *Options) (Target, error) {
+		return newTemplateTarget(hugoPreset, opts.TargetDefinition)
+	},
+}
+
+// RegisterTarget adds a target which can be selected by the target option
+func RegisterTarget(name string, factory TargetFactory) {
+	targetFactories[name] = factory
+}
+
+func newTarget(opts *Options) (Target, error) {
+	name := opts.Target
+	if name == "" {
+		name = "jekyll"
+	}
+
+	factory, ok := targetFactories[name]
+	if !ok {
+		names := make([]string, 0, len(targetFactories))
+		for name := range targetFactories {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("unknown target %v (available: %v)", name, names)
+	}
+	return factory(opts)
+}
+
+// templateTarget is a Target driven by a TargetDefinition
+type templateTarget struct {
+	def TargetDefinition
+}
+
+// newTemplateTarget overrides preset with non-blank fields of def
+func newTemplateTarget(preset TargetDefinition, def TargetDefinition) (*templateTarget, error) {
+	merged := preset
+	override := func(value *string, by string) {
+		if by != "" {
+			*value = by
+		}
+	}
+	override(&merged.PostPath, def.PostPath)
+	override(&merged.PagePath, def.PagePath)
+	override(&merged.DraftPath, def.DraftPath)
+	override(&merged.Permalink, def.Permalink)
+	override(&merged.PagePermalink, def.PagePermalink)
+	override(&merged.PostURL, def.PostURL)
+	override(&merged.PageURL, def.PageURL)
+	override(&merged.RedirectDir, def.RedirectDir)
+	override(&merged.ResourceDir, def.ResourceDir)
+	override(&merged.ResourceURL, def.ResourceURL)
+	override(&merged.FrontMatter.Format, def.FrontMatter.Format)
+	override(&merged.FrontMatter.Open, def.FrontMatter.Open)
+	override(&merged.FrontMatter.Close, def.FrontMatter.Close)
+	override(&merged.FrontMatter.DateFormat, def.FrontMatter.DateFormat)
+	if def.URLPrefix != nil {
+		merged.URLPrefix = def.URLPrefix
+	}
+	if def.CodeBlock != nil {
+		merged.CodeBlock = def.CodeBlock
+	}
+	// fields are merged key by key, so that a blank key still omits the field
+	if def.FrontMatter.Fields != nil {
+		merged.FrontMatter.Fields = map[string]string{}
+		for field, key := range preset.FrontMatter.Fields {
+			merged.FrontMatter.Fields[field] = key
+		}
+		for field, key := range def.FrontMatter.Fields {
+			merged.FrontMatter.Fields[field] = key
+		}
+	}
+	if def.Media != nil {
+		merged.Media = def.Media
+	}
+
+	if merged.FrontMatter.Open == "" && merged.FrontMatter.Close == "" {
+		switch merged.FrontMatter.Format {
+		case "", frontMatterYAML:
+			merged.FrontMatter.Open, merged.FrontMatter.Close = "---", "---"
+		case frontMatterTOML:
+			merged.FrontMatter.Open, merged.FrontMatter.Close = "+++", "+++"
+		}
+	}
+
+	t := &templateTarget{def: merged}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *templateTarget) validate() error {
+	pathVars := t.pathVars(&Post{})
+	for _, pattern := range []string{t.def.PostPath, t.def.PagePath, t.def.DraftPath, t.def.Permalink, t.def.PagePermalink, t.def.PostURL, t.def.PageURL} {
+		if _, err := expandPattern(pattern, pathVars); err != nil {
+			return errors.Wrap(err, "invalid target definition")
+		}
+	}
+
+	pathVars["post_dir"] = ""
+	if _, err := expandPattern(t.def.ResourceDir, pathVars); err != nil {
+		return errors.Wrap(err, "invalid target definition")
+	}
+
+	pathVars["resource_dir"] = ""
+	pathVars["file"] = ""
+	if _, err := expandPattern(t.def.ResourceURL, pathVars); err != nil {
+		return errors.Wrap(err, "invalid target definition")
+	}
+	if _, err := expandPattern(t.codeBlockTemplate(), map[string]string{"lang": "", "code": ""}); err != nil {
+		return errors.Wrap(err, "invalid target definition")
+	}
+	for kind, template := range t.def.Media {
+		switch kind {
+		case mediaImage, mediaAudio, mediaVideo, mediaFile:
+		default:
+			return errors.Errorf("invalid target definition: unknown media %v", kind)
+		}
+		if _, err := expandPattern(template, map[string]string{"url": "", "name": ""}); err != nil {
+			return errors.Wrap(err, "invalid target definition")
+		}
+	}
+
+	for field := range t.def.FrontMatter.Fields {
+		known := false
+		for _, f := range frontMatterFieldOrder {
+			known = known || f == field
+		}
+		if !known {
+			return errors.Errorf("invalid target definition: unknown front matter field %v", field)
+		}
+	}
+	if _, err := newFrontMatter().marshal(t.def.FrontMatter.Format, t.def.FrontMatter.DateFormat); err != nil {
+		return errors.Wrap(err, "invalid target definition")
+	}
+	return nil
+}
+
+func (t *templateTarget) pathVars(post *Post) map[string]string {
+	return map[string]string{
+		"year":       post.Date.Format("2006"),
+		"month":      post.Date.Format("01"),
+		"day":        post.Date.Format("02"),
+		"slug":       post.Slug,
+		"notebook":   post.Notebook,
+		"guid":       post.GUID,
+		"ext":        post.Extension,
+		"categories": categoriesPath(post),
+	}
+}
+
+// categoriesPath returns categories and category fields of the post joined like jekyll's :categories, e.g. "/a/b"
+func categoriesPath(post *Post) string {
+	var categories []string
+	seen := map[string]bool{}
+	for _, key := range []string{"categories", "category"} {
+		var values []string
+		switch value := post.Fields[key].(type) {
+		case string:
+			values = strings.Fields(value)
+		case []interface{}:
+			for _, v := range value {
+				values = append(values, fmt.Sprint(v))
+			}
+		}
+		for _, category := range values {
+			category = strings.ToLower(category)
+			if category != "" && !seen[category] {
+				seen[category] = true
+				categories = append(categories, url.PathEscape(category))
+			}
+		}
+	}
+	if len(categories) == 0 {
+		return ""
+	}
+	return "/" + strings.Join(categories, "/")
+}
+
+// expand expands a pattern which is already validated
+func (t *templateTarget) expand(pattern string, vars map[string]string) string {
+	expanded, _ := expandPattern(pattern, vars)
+	return expanded
+}
+
+func (t *templateTarget) PostPath(post *Post) string {
+	if post.Page {
+		return t.expand(t.def.PagePath, t.pathVars(post))
+	}
+	if post.Draft && t.def.DraftPath != "" {
+		return t.expand(t.def.DraftPath, t.pathVars(post))
+	}
+	return t.expand(t.def.PostPath, t.pathVars(post))
+}
+
+func (t *templateTarget) permalink(post *Post) string {
+	if post.Page {
+		return t.expand(t.def.PagePermalink, t.pathVars(post))
+	}
+	return t.expand(t.def.Permalink, t.pathVars(post))
+}
+
+func (t *templateTarget) URL(post *Post) string {
+	if permalink := t.permalink(post); permalink != "" {
+		return permalink
+	}
+	if post.Page {
+		return t.expand(t.def.PageURL, t.pathVars(post))
+	}
+	return t.expand(t.def.PostURL, t.pathVars(post))
+}
+
+// RedirectPath returns index.html in the directory for urls which don't end with a file name
+func (t *templateTarget) RedirectPath(url string) string {
+	if strings.HasSuffix(url, "/") || path.Ext(url) == "" {
+		url = path.Join(url, "index.html")
+	}
+	return path.Join(t.def.RedirectDir, url)
+}
+
+func (t *templateTarget) ResourceDir(post *Post) string {
+	vars := t.pathVars(post)
+	vars["post_dir"] = path.Dir(t.PostPath(post))
+	return t.expand(t.def.ResourceDir, vars)
+}
+
+func (t *templateTarget) ResourceURL(post *Post, fileName string) string {
+	vars := t.pathVars(post)
+	vars["post_dir"] = path.Dir(t.PostPath(post))
+	vars["resource_dir"] = t.ResourceDir(post)
+	vars["file"] = fileName
+
+	url := t.expand(t.def.ResourceURL, vars)
+	if strings.HasPrefix(url, "/") && t.def.URLPrefix != nil {
+		url = *t.def.URLPrefix + url
+	}
+	return url
+}
+
+func (t *templateTarget) FrontMatter(post *Post) ([]byte, error) {
+	layout := "post"
+	if post.Page {
+		layout = "page"
+	}
+
+	fm := newFrontMatter()
+	for _, field := range frontMatterFieldOrder {
+		key := t.def.FrontMatter.Fields[field]
+		if key == "" {
+			continue
+		}
+
+		switch field {
+		case "title":
+			fm.set(key, post.Title)
+		case "layout":
+			fm.set(key, layout)
+		case "published":
+			// jekyll doesn't show drafts which aren't published even with --drafts
+			if !post.Draft {
+				fm.set(key, post.Published)
+			}
+		case "draft":
+			if !post.Published {
+				fm.set(key, true)
+			}
+		case "date":
+			fm.set(key, post.Date)
+		case "last_modified":
+			if !post.Updated.IsZero() {
+				fm.set(key, post.Updated)
+			}
+		case "permalink":
+			if permalink := t.permalink(post); permalink != "" {
+				fm.set(key, permalink)
+			}
+		case "redirect_from":
+			if len(post.RedirectFrom) > 0 {
+				fm.set(key, post.RedirectFrom)
+			}
+		case "tags":
+			if len(post.Tags) > 0 {
+				fm.set(key, post.Tags)
+			}
+		}
+	}
+
+	for _, key := range sortedFieldKeys(post.Fields) {
+		fm.set(key, post.Fields[key])
+	}
+
+	fmBytes, err := fm.marshal(t.def.FrontMatter.Format, t.def.FrontMatter.DateFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	var block []byte
+	if t.def.FrontMatter.Open != "" {
+		block = append(block, t.def.FrontMatter.Open+"\n"...)
+	}
+	block = append(block, fmBytes...)
+	if t.def.FrontMatter.Close != "" {
+		block = append(block, t.def.FrontMatter.Close+"\n"...)
+	}
+	return block, nil
+}
+
+func (t *templateTarget) codeBlockTemplate() string {
+	if t.def.CodeBlock == nil {
+		return ""
+	}
+	return *t.def.CodeBlock
+}
+
+func (t *templateTarget) CodeBlock(language string, code string) string {
+	return t.expand(t.codeBlockTemplate(), map[string]string{"lang": language, "code": code})
+}
+
+func (t *templateTarget) Media(kind string, url string, name string) string {
+	return t.expand(t.def.Media[kind], map[string]string{"url": url, "name": name})
+}
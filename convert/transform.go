@@ -0,0 +1,176 @@
+package convert
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// TransformContext is what transformers know about the note being converted
+type TransformContext struct {
+	Post    *Post
+	Target  Target
+	Options *Options
+	// Resources are cached resource files, whose names start with the hash of their contents
+	Resources []os.FileInfo
+	// ReferencedResources are names of resource files used in the note, which are copied if it is published
+	ReferencedResources []string
+}
+
+// Transformer converts the document of a note before it is rendered as html or markdown.
+// Code blocks must be left as <pre data-lang="..."> to be rendered for each format.
+type Transformer interface {
+	Transform(doc *goquery.Document, ctx *TransformContext) error
+}
+
+// TransformerFunc is a function which is a Transformer
+type TransformerFunc func(doc *goquery.Document, ctx *TransformContext) error
+
+// Transform calls f
+func (f TransformerFunc) Transform(doc *goquery.Document, ctx *TransformContext) error {
+	return f(doc, ctx)
+}
+
+var transformers = map[string]Transformer{
+	"web_clip":   TransformerFunc(transformWebClip),
+	"decrypt":    TransformerFunc(transformDecrypt),
+	"todo":       TransformerFunc(transformTodos),
+	"code_fence": TransformerFunc(transformCodeFences),
+	"code_block": TransformerFunc(transformCodeBlocks),
+	"heading":    TransformerFunc(transformHeadings),
+	"markdown":   TransformerFunc(transformMarkdownSyntax),
+	"table":      TransformerFunc(transformTables),
+	"media":      TransformerFunc(transformMedia),
+	"sanitize":   TransformerFunc(transformSanitize),
+}
+
+// defaultTransforms are names of transformers applied in order unless the transforms option is set
+var defaultTransforms = []string{"web_clip", "decrypt", "todo", "code_fence", "code_block", "heading", "markdown", "table", "media", "sanitize"}
+
+// RegisterTransformer adds a transformer which can be listed in the transforms option
+func RegisterTransformer(name string, transformer Transformer) {
+	transformers[name] = transformer
+}
+
+// namedTransformer is a transformer with its name for error messages
+type namedTransformer struct {
+	name        string
+	transformer Transformer
+}
+
+// newTransformers returns transformers in the order of names, or the default ones if names are empty
+func newTransformers(names []string) ([]namedTransformer, error) {
+	if len(names) == 0 {
+		names = defaultTransforms
+	}
+
+	pipeline := make([]namedTransformer, 0, len(names))
+	for _, name := range names {
+		transformer, ok := transformers[name]
+		if !ok {
+			available := make([]string, 0, len(transformers))
+			for name := range transformers {
+				available = append(available, name)
+			}
+			sort.Strings(available)
+			return nil, errors.Errorf("unknown transform %v (available: %v)", name, available)
+		}
+		pipeline = append(pipeline, namedTransformer{name: name, transformer: transformer})
+	}
+	return pipeline, nil
+}
+
+// transform applies transformers to the document of the note in order
+func transform(doc *goquery.Document, pipeline []namedTransformer, ctx *TransformContext) error {
+	for _, t := range pipeline {
+		if err := t.transformer.Transform(doc, ctx); err != nil {
+			return errors.Wrapf(err, "transform %v failed", t.name)
+		}
+	}
+	return nil
+}
+
+func transformTodos(doc *goquery.Document, ctx *TransformContext) error {
+	doc.Find("en-todo").Each(func(_ int, todo *goquery.Selection) {
+		if checked, _ := todo.Attr("checked"); checked == "true" {
+			todo.ReplaceWithHtml(`<input type="checkbox" checked="checked"/>`)
+		} else {
+			todo.ReplaceWithHtml(`<input type="checkbox"/>`)
+		}
+	})
+	return nil
+}
+
+// markdownHeadingLine is a line which starts with "# " to "##### "
+var markdownHeadingLine = regexp.MustCompile(`^#{1,5} `)
+
+// transformHeadings turns lines starting with "# " to "##### " into headings of their levels
+func transformHeadings(doc *goquery.Document, ctx *TransformContext) error {
+	for _, div := range doc.Find("en-note div").Nodes {
+		if !isLine(div) {
+			continue
+		}
+		text := strings.TrimSpace(strings.Replace(textContent(div), "\u00a0", " ", -1))
+		marker := markdownHeadingLine.FindString(text)
+		if marker == "" {
+			continue
+		}
+
+		trimLeadingText(div, marker)
+		// a line may end with <br>, which is the line break itself
+		if last := div.LastChild; last != nil && last.Type == nethtml.ElementNode && last.Data == "br" {
+			div.RemoveChild(last)
+		}
+		tag := "h" + strconv.Itoa(len(marker)-1)
+		div.Data, div.DataAtom = tag, atom.Lookup([]byte(tag))
+	}
+	return nil
+}
+
+func transformMedia(doc *goquery.Document, ctx *TransformContext) error {
+	doc.Find("en-media").Each(func(i int, selection *goquery.Selection) {
+		hash, _ := selection.Attr("hash")
+		found := false
+		for _, resourceFile := range ctx.Resources {
+			if !strings.HasPrefix(resourceFile.Name(), hash) {
+				continue
+			}
+
+			found = true
+			ctx.ReferencedResources = append(ctx.ReferencedResources, resourceFile.Name())
+			url := ctx.Target.ResourceURL(ctx.Post, resourceFile.Name())
+			kind := mediaKind(resourceFile.Name())
+			name := resourceDisplayName(resourceFile.Name())
+
+			if markup := ctx.Target.Media(kind, url, name); markup != "" {
+				replaceWithRaw(selection, markup)
+				continue
+			}
+
+			switch kind {
+			case mediaImage:
+				selection.ReplaceWithHtml(fmt.Sprintf(`<img src="%v" />`, html.EscapeString(url)))
+			case mediaAudio:
+				selection.ReplaceWithHtml(fmt.Sprintf(`<audio src="%v" controls="true"/>`, html.EscapeString(url)))
+			case mediaVideo:
+				selection.ReplaceWithHtml(fmt.Sprintf(`<video src="%v" controls="true"/>`, html.EscapeString(url)))
+			default:
+				selection.ReplaceWithHtml(fmt.Sprintf(`<a href="%v">%v</a>`, html.EscapeString(url), html.EscapeString(name)))
+			}
+		}
+
+		if !found {
+			fmt.Printf("can't find resource %v\n", hash)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,295 @@
+package convert
+
+import (
+	"html"
+	"math"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dreampuf/evernote-sdk-golang/types"
+	"github.com/pkg/errors"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	defaultWebClipField       = "web_clip"
+	defaultWebClipAttribution = `<p>Source: <a href="{{url}}">{{host}}</a></p>`
+)
+
+// WebClipOptions configure notes clipped from web pages
+type WebClipOptions struct {
+	// Field is a front matter key set to true for web clips, "web_clip" by default
+	Field string `yaml:"field,omitempty"`
+
+	// Attribution is html added at the end of web clips with {{url}}, {{host}} and {{title}} of the source
+	Attribution string `yaml:"attribution,omitempty"`
+
+	// FromSourceURL treats notes with a http source url and no source as web clips.
+	// It is off by default, as the source url is also used to name posts.
+	FromSourceURL bool `yaml:"from_source_url,omitempty"`
+}
+
+func (opts *WebClipOptions) validate() error {
+	_, err := opts.attribution("", "")
+	return err
+}
+
+func (opts *WebClipOptions) field() string {
+	if opts.Field == "" {
+		return defaultWebClipField
+	}
+	return opts.Field
+}
+
+// attribution returns the attribution block of the source
+func (opts *WebClipOptions) attribution(sourceURL string, title string) (string, error) {
+	template := opts.Attribution
+	if template == "" {
+		template = defaultWebClipAttribution
+	}
+	host := sourceURL
+	if u, err := url.Parse(sourceURL); err == nil && u.Host != "" {
+		host = strings.TrimPrefix(u.Host, "www.")
+	}
+	return expandPattern(template, map[string]string{
+		"url":   html.EscapeString(sourceURL),
+		"host":  html.EscapeString(host),
+		"title": html.EscapeString(title),
+	})
+}
+
+// isWebClip reports whether the note is clipped from a web page by its source or content class,
+// or by its source url if the note doesn't tell where it comes from and FromSourceURL is set
+func (opts *WebClipOptions) isWebClip(attrs *types.NoteAttributes) bool {
+	if attrs == nil {
+		return false
+	}
+	if source, ok := stringValue(attrs.Source).(string); ok {
+		return strings.HasPrefix(source, "web.clip") || source == "Clearly" || source == "mobile.web.clip"
+	}
+	if class, ok := stringValue(attrs.ContentClass).(string); ok {
+		return strings.Contains(strings.ToLower(class), "clip")
+	}
+	if !opts.FromSourceURL {
+		return false
+	}
+	sourceURL, _ := stringValue(attrs.SourceURL).(string)
+	return strings.HasPrefix(sourceURL, "http://") || strings.HasPrefix(sourceURL, "https://")
+}
+
+// transformWebClip extracts the main content of web clips and adds the attribution block linking the source
+func transformWebClip(doc *goquery.Document, ctx *TransformContext) error {
+	if !ctx.Post.WebClip {
+		return nil
+	}
+
+	for _, note := range doc.Find("en-note").Nodes {
+		removeClipJunk(note)
+		if main := mainContent(note); main != nil {
+			for _, n := range main {
+				n.Parent.RemoveChild(n)
+			}
+			for note.FirstChild != nil {
+				note.RemoveChild(note.FirstChild)
+			}
+			for _, n := range main {
+				note.AppendChild(n)
+			}
+		}
+
+		if ctx.Post.SourceURL == "" {
+			continue
+		}
+		attribution, err := ctx.Options.WebClips.attribution(ctx.Post.SourceURL, ctx.Post.Title)
+		if err != nil {
+			return err
+		}
+		nodes, err := nethtml.ParseFragment(strings.NewReader(attribution), &nethtml.Node{Type: nethtml.ElementNode, Data: "div", DataAtom: atom.Div})
+		if err != nil {
+			return errors.Wrap(err, "can't parse attribution")
+		}
+		for _, n := range nodes {
+			note.AppendChild(n)
+		}
+	}
+	return nil
+}
+
+// removeClipJunk unwraps wrappers added by the web clipper and removes hidden elements
+func removeClipJunk(n *nethtml.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type != nethtml.ElementNode {
+			c = next
+			continue
+		}
+
+		style := strings.ToLower(strings.Replace(attr(c, "style"), " ", "", -1))
+		switch {
+		case strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden"):
+			n.RemoveChild(c)
+		case strings.Contains(style, "-evernote-webclip") || strings.Contains(style, "--en-clipped-content"):
+			removeClipJunk(c)
+			for c.FirstChild != nil {
+				child := c.FirstChild
+				c.RemoveChild(child)
+				n.InsertBefore(child, c)
+			}
+			n.RemoveChild(c)
+		default:
+			removeClipJunk(c)
+		}
+		c = next
+	}
+}
+
+// mainContentElements are elements which can hold the main content of web pages
+var mainContentElements = map[string]bool{
+	"en-note": true, "div": true, "td": true, "blockquote": true, "section": true, "article": true, "center": true,
+}
+
+// mainContent returns the element which has the most paragraphs of text and its siblings which are also content,
+// like readers of browsers do. It returns nil if the whole of root is the main content.
+func mainContent(root *nethtml.Node) []*nethtml.Node {
+	// paragraphs are scored to their parents, and to their grandparents by half which may hold sections of them
+	scores := map[*nethtml.Node]float64{}
+	var candidates []*nethtml.Node
+	var walk func(*nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		candidates = append(candidates, n)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == nethtml.ElementNode {
+				walk(c)
+			}
+			if score := paragraphScore(c); score > 0 {
+				if mainContentElements[n.Data] {
+					scores[n] += score
+				}
+				if n != root && mainContentElements[n.Parent.Data] {
+					scores[n.Parent] += score / 2
+				}
+			}
+		}
+	}
+	walk(root)
+
+	// candidates are in document order, so that ancestors win ties
+	var top *nethtml.Node
+	for _, n := range candidates {
+		if scores[n] > 0 && (top == nil || scores[n] > scores[top]) {
+			top = n
+		}
+	}
+	if top == nil {
+		return nil
+	}
+	// other candidates as good as the top one are sections of the same content
+	for _, n := range candidates {
+		if n != top && scores[n] >= scores[top]*0.75 {
+			top = commonAncestor(top, n)
+		}
+	}
+	if top == root {
+		return nil
+	}
+
+	threshold := math.Max(10, scores[top]*0.2)
+	included := map[*nethtml.Node]bool{top: true}
+	for c := top.Parent.FirstChild; c != nil; c = c.NextSibling {
+		if c != top && (scores[c] >= threshold || paragraphScore(c) > 80) {
+			included[c] = true
+		}
+	}
+	// headings are kept with the content after them
+	for c := top.Parent.LastChild; c != nil; c = c.PrevSibling {
+		if next := nextElement(c); isHeading(c) && next != nil && included[next] {
+			included[c] = true
+		}
+	}
+
+	var main []*nethtml.Node
+	for c := top.Parent.FirstChild; c != nil; c = c.NextSibling {
+		if included[c] {
+			main = append(main, c)
+		}
+	}
+	return main
+}
+
+// paragraphScore is the length of text of the paragraph without links, or 0 if n isn't a paragraph
+func paragraphScore(n *nethtml.Node) float64 {
+	if !isParagraph(n) {
+		return 0
+	}
+	text := len(strings.TrimSpace(textContent(n)))
+	// short lines are mostly navigations and buttons
+	if text < 25 {
+		return 0
+	}
+	return float64(text) * (1 - linkDensity(n))
+}
+
+func commonAncestor(a *nethtml.Node, b *nethtml.Node) *nethtml.Node {
+	ancestors := map[*nethtml.Node]bool{}
+	for n := a; n != nil; n = n.Parent {
+		ancestors[n] = true
+	}
+	for n := b; n != nil; n = n.Parent {
+		if ancestors[n] {
+			return n
+		}
+	}
+	return nil
+}
+
+func nextElement(n *nethtml.Node) *nethtml.Node {
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == nethtml.ElementNode {
+			return c
+		}
+	}
+	return nil
+}
+
+func isHeading(n *nethtml.Node) bool {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return true
+	}
+	return false
+}
+
+func isParagraph(n *nethtml.Node) bool {
+	switch {
+	case n.Type == nethtml.TextNode:
+		return true
+	case n.Type != nethtml.ElementNode:
+		return false
+	case n.Data == "p" || n.Data == "pre" || n.Data == "ul" || n.Data == "ol" || n.Data == "blockquote":
+		return true
+	}
+	return n.Data == "div" && !hasBlockChild(n)
+}
+
+// linkDensity is the ratio of text in links to all text of n
+func linkDensity(n *nethtml.Node) float64 {
+	text := len(textContent(n))
+	if text == 0 {
+		return 0
+	}
+	links := 0
+	var walk func(*nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		if n.Type == nethtml.ElementNode && n.Data == "a" {
+			links += len(textContent(n))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return float64(links) / float64(text)
+}
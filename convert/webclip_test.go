@@ -0,0 +1,80 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestMainContent(t *testing.T) {
+	long := "This paragraph is long enough to be a part of the article text."
+	tests := []struct {
+		name    string
+		note    string
+		keep    []string
+		discard []string
+	}{
+		{
+			"sections",
+			`<en-note>
+				<div><a href="/">Home</a> <a href="/about">About</a> <a href="/blog">Blog</a></div>
+				<div>
+					<h1>Title</h1>
+					<div><h2>Part 1</h2><p>First ` + long + `</p><p>Second ` + long + `</p></div>
+					<div><h2>Part 2</h2><p>Third ` + long + `</p></div>
+					<div><h2>Part 3</h2><p>Fourth ` + long + `</p><p>Fifth ` + long + `</p><p>Sixth ` + long + `</p></div>
+				</div>
+				<div><a href="/privacy">Privacy</a> <a href="/terms">Terms</a></div>
+			</en-note>`,
+			[]string{"Title", "Part 1", "First", "Second", "Part 2", "Third", "Part 3", "Fourth", "Sixth"},
+			[]string{"Home", "Privacy"},
+		},
+		{
+			"nested sections",
+			`<en-note>
+				<div><a href="/">Home</a> <a href="/about">About</a></div>
+				<div>
+					<div><div><p>First ` + long + `</p><p>Second ` + long + `</p></div></div>
+					<div><div><p>Third ` + long + `</p><p>Fourth ` + long + `</p></div></div>
+				</div>
+			</en-note>`,
+			[]string{"First", "Fourth"},
+			[]string{"Home"},
+		},
+		{
+			"sidebar",
+			`<en-note>
+				<div><p>First ` + long + `</p><p>Second ` + long + `</p><p>Third ` + long + `</p></div>
+				<div><ul><li><a href="/1">Another article with a long title</a></li><li><a href="/2">Yet another article</a></li></ul></div>
+			</en-note>`,
+			[]string{"First", "Third"},
+			[]string{"Another article"},
+		},
+	}
+	for _, test := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(test.note))
+		if err != nil {
+			t.Fatal(err)
+		}
+		main := mainContent(doc.Find("en-note").Nodes[0])
+		if main == nil {
+			t.Errorf("%v: the whole note is the main content", test.name)
+			continue
+		}
+		var text strings.Builder
+		for _, n := range main {
+			text.WriteString(textContent(n))
+		}
+		for _, s := range test.keep {
+			if !strings.Contains(text.String(), s) {
+				t.Errorf("%v: %q is discarded", test.name, s)
+			}
+		}
+		for _, s := range test.discard {
+			if strings.Contains(text.String(), s) {
+				t.Errorf("%v: %q is kept", test.name, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// fileWriter applies file operations to the jekyll tree.
+// In dry-run mode it only prints what would be created, overwritten or removed.
+// It refuses to overwrite files which aren't listed in the previous manifest,
+// reports files which it takes over on the first run, and doesn't rewrite files whose content is unchanged.
+type fileWriter struct {
+	dryRun     bool
+	previous   *manifest
+	configHash string
+	generated  map[string]bool
+	notes      map[string]noteRecord
+	urls       map[string][]string
+}
+
+func newFileWriter(dryRun bool, previous *manifest, configHash string) *fileWriter {
+	w := &fileWriter{
+		dryRun:     dryRun,
+		previous:   previous,
+		configHash: configHash,
+		generated:  map[string]bool{},
+		notes:      map[string]noteRecord{},
+		urls:       map[string][]string{},
+	}
+	// urls are kept even if notes are converted again with another config
+	if previous != nil {
+		for guid, urls := range previous.URLs {
+			w.urls[guid] = urls
+		}
+	}
+	return w
+}
+
+func (w *fileWriter) exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// claim records p as a generated file.
+// Existing files are only taken over on the first run, when there is no manifest yet, and they are reported
+// as they are overwritten and removed later like generated files.
+func (w *fileWriter) claim(p string) error {
+	p = filepath.Clean(p)
+	if w.generated[p] {
+		return nil
+	}
+	if w.exists(p) {
+		if w.previous != nil && !w.previous.owns(p) {
+			return errors.Errorf("%v already exists and isn't generated by chienote", p)
+		}
+		if w.previous == nil {
+			if w.dryRun {
+				fmt.Printf("[dry-run] would take over existing %v\n", p)
+			} else {
+				fmt.Printf("taking over existing %v\n", p)
+			}
+		}
+	}
+	w.generated[p] = true
+	return nil
+}
+
+// noteUnchanged reports whether the note was converted with the same update sequence number, config,
+// publication and redirects, which change without updates of scheduled notes or when other notes take over urls.
+// Files of an unchanged note are kept as generated files.
+func (w *fileWriter) noteUnchanged(guid string, usn int32, published bool, redirects []string) bool {
+	if w.previous == nil || w.previous.ConfigHash != w.configHash {
+		return false
+	}
+
+	record, ok := w.previous.Notes[guid]
+	if !ok || record.UpdateSequenceNum != usn || record.Published != published || !sameStrings(record.Redirects, redirects) {
+		return false
+	}
+	for _, p := range record.Files {
+		if !w.exists(p) {
+			return false
+		}
+	}
+
+	for _, p := range record.Files {
+		w.generated[p] = true
+	}
+	w.notes[guid] = record
+	return true
+}
+
+func (w *fileWriter) recordNote(guid string, record noteRecord) {
+	w.notes[guid] = record
+}
+
+// formerURLs returns the urls the note was published at other than url
+func (w *fileWriter) formerURLs(guid string, url string) []string {
+	var former []string
+	for _, u := range w.urls[guid] {
+		if u != url {
+			former = append(former, u)
+		}
+	}
+	return former
+}
+
+// publishedAt records url as the current url of the note, which must be called after its post is written
+func (w *fileWriter) publishedAt(guid string, url string) {
+	w.urls[guid] = append(w.formerURLs(guid, url), url)
+}
+
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceUnchanged reports whether the resource was copied by the previous run.
+// Resource file names start with the hash of their contents, so the same name means the same content.
+func (w *fileWriter) resourceUnchanged(p string) bool {
+	p = filepath.Clean(p)
+	if w.generated[p] {
+		return true
+	}
+	if w.previous == nil || !w.previous.owns(p) || !w.exists(p) {
+		return false
+	}
+	w.generated[p] = true
+	return true
+}
+
+func (w *fileWriter) mkdirAll(dir string) error {
+	if w.dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "can't create directory %v", dir)
+	}
+	return nil
+}
+
+// removeStale removes files which were generated by the previous run but not by this one
+func (w *fileWriter) removeStale() error {
+	if w.previous == nil {
+		return nil
+	}
+
+	// only directories of notes are removed, and shared ones like _posts are kept even if they are left empty
+	noteDirs := map[string]bool{}
+	for _, record := range w.previous.Notes {
+		for _, dir := range record.Dirs {
+			noteDirs[filepath.Clean(dir)] = true
+		}
+	}
+
+	for _, p := range w.previous.Files {
+		if w.generated[p] {
+			continue
+		}
+		if w.dryRun {
+			fmt.Printf("[dry-run] would remove %v\n", p)
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "can't remove stale file %v", p)
+		}
+		// remove the directory of the note too if it is left empty, e.g. a per-post resource directory
+		if dir := filepath.Dir(p); noteDirs[dir] {
+			os.Remove(dir)
+		}
+	}
+	return nil
+}
+
+// keepPrevious keeps files of the previous manifest owned without removing them.
+// Notes which aren't converted in this run are forgotten, so they are converted again next time.
+func (w *fileWriter) keepPrevious() {
+	if w.previous == nil {
+		return
+	}
+	for _, p := range w.previous.Files {
+		w.generated[p] = true
+	}
+}
+
+func (w *fileWriter) saveManifest(manifestPath string) error {
+	if w.dryRun {
+		return nil
+	}
+	return saveManifest(manifestPath, w.configHash, w.generated, w.notes, w.urls)
+}
+
+func (w *fileWriter) report(p string) {
+	if w.exists(p) {
+		fmt.Printf("[dry-run] would overwrite %v\n", p)
+	} else {
+		fmt.Printf("[dry-run] would create %v\n", p)
+	}
+}
+
+func (w *fileWriter) writeFile(p string, data []byte) error {
+	if err := w.claim(p); err != nil {
+		return err
+	}
+	if current, err := ioutil.ReadFile(p); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	if w.dryRun {
+		w.report(p)
+		return nil
+	}
+	if err := w.mkdirAll(filepath.Dir(p)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(p, data, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "can't write file %v", p)
+	}
+	return nil
+}
+
+func (w *fileWriter) copyFile(sourcePath string, destPath string) error {
+	if err := w.claim(destPath); err != nil {
+		return err
+	}
+	if w.dryRun {
+		w.report(destPath)
+		return nil
+	}
+	if err := w.mkdirAll(filepath.Dir(destPath)); err != nil {
+		return err
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return errors.Wrapf(err, "can't open resource cache file %v", sourcePath)
+	}
+	defer source.Close()
+
+	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return errors.Wrapf(err, "can't create resource file %v", destPath)
+	}
+	defer dest.Close()
+
+	if _, err := io.Copy(dest, source); err != nil {
+		return errors.Wrapf(err, "can't copy resource file %v", sourcePath)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package convert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "chienote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeTestFile(t *testing.T, p string) {
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte("test"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous *manifest
+		exists   bool
+		owned    bool
+		wantErr  bool
+	}{
+		{"new file on the first run", nil, false, false, false},
+		{"existing file taken over on the first run", nil, true, false, false},
+		{"new file", &manifest{}, false, false, false},
+		{"existing generated file", &manifest{}, true, true, false},
+		{"existing file not generated", &manifest{}, true, false, true},
+	}
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		p := filepath.Join(dir, "_posts", "post.md")
+		if test.exists {
+			writeTestFile(t, p)
+		}
+		if test.owned {
+			test.previous.Files = []string{p}
+		}
+
+		for _, dryRun := range []bool{false, true} {
+			w := newFileWriter(dryRun, test.previous, "hash")
+			err := w.claim(p)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("%v (dry-run %v): got error %v", test.name, dryRun, err)
+			}
+			if generated := w.generated[p]; generated == test.wantErr {
+				t.Errorf("%v (dry-run %v): generated is %v", test.name, dryRun, generated)
+			}
+		}
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+		exists map[string]bool
+	}{
+		{"remove", false, map[string]bool{
+			"_posts/stale.md":       false,
+			"_posts/kept.md":        true,
+			"files/stale":           false,
+			"files/stale/image.png": false,
+			"files/kept/image.png":  true,
+		}},
+		{"dry-run", true, map[string]bool{
+			"_posts/stale.md":       true,
+			"_posts/kept.md":        true,
+			"files/stale/image.png": true,
+			"files/kept/image.png":  true,
+		}},
+	}
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		join := func(p string) string { return filepath.Join(dir, filepath.FromSlash(p)) }
+
+		previous := &manifest{Notes: map[string]noteRecord{
+			"stale": {Dirs: []string{join("files/stale")}, Files: []string{join("_posts/stale.md"), join("files/stale/image.png")}},
+			"kept":  {Dirs: []string{join("files/kept")}, Files: []string{join("_posts/kept.md"), join("files/kept/image.png")}},
+		}}
+		for _, record := range previous.Notes {
+			for _, p := range record.Files {
+				writeTestFile(t, p)
+				previous.Files = append(previous.Files, p)
+			}
+		}
+
+		w := newFileWriter(test.dryRun, previous, "hash")
+		for _, p := range previous.Notes["kept"].Files {
+			if err := w.claim(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.removeStale(); err != nil {
+			t.Fatal(err)
+		}
+		for p, want := range test.exists {
+			if got := w.exists(join(p)); got != want {
+				t.Errorf("%v: %v exists is %v, want %v", test.name, p, got, want)
+			}
+		}
+		// shared directories are kept even if they are left empty
+		if !w.exists(join("_posts")) {
+			t.Errorf("%v: _posts is removed", test.name)
+		}
+	}
+}
+
+func TestNoteUnchanged(t *testing.T) {
+	tests := []struct {
+		name       string
+		configHash string
+		usn        int32
+		published  bool
+		redirects  []string
+		removed    bool
+		want       bool
+	}{
+		{"unchanged", "hash", 10, true, []string{"/old/"}, false, true},
+		{"config changed", "other", 10, true, []string{"/old/"}, false, false},
+		{"note updated", "hash", 11, true, []string{"/old/"}, false, false},
+		{"unpublished", "hash", 10, false, []string{"/old/"}, false, false},
+		{"redirects changed", "hash", 10, true, nil, false, false},
+		{"file removed", "hash", 10, true, []string{"/old/"}, true, false},
+	}
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		p := filepath.Join(dir, "_posts", "post.md")
+		if !test.removed {
+			writeTestFile(t, p)
+		}
+		previous := &manifest{
+			ConfigHash: "hash",
+			Files:      []string{p},
+			Notes:      map[string]noteRecord{"guid": {UpdateSequenceNum: 10, Published: true, Redirects: []string{"/old/"}, Files: []string{p}}},
+		}
+
+		w := newFileWriter(false, previous, test.configHash)
+		if got := w.noteUnchanged("guid", test.usn, test.published, test.redirects); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+		if w.generated[p] != test.want {
+			t.Errorf("%v: generated is %v", test.name, w.generated[p])
+		}
+	}
+
+	w := newFileWriter(false, nil, "hash")
+	if w.noteUnchanged("guid", 10, true, nil) {
+		t.Error("first run: note is unchanged")
+	}
+}
+
+func TestCheckCollisions(t *testing.T) {
+	target, err := newTemplateTarget(jekyllPreset, TargetDefinition{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slug := &SlugOptions{}
+	day := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		titles  []string
+		dates   []time.Time
+		wantErr bool
+	}{
+		{"different titles", []string{"Hello", "World"}, []time.Time{day, day}, false},
+		{"same slug", []string{"Hello, World!", "hello world"}, []time.Time{day, day}, true},
+		{"same slug on different days", []string{"Hello, World!", "hello world"}, []time.Time{day, day.AddDate(0, 0, 1)}, false},
+		{"titles without letters", []string{"!!!", "???"}, []time.Time{day, day}, false},
+	}
+	for _, test := range tests {
+		var notes []*cachedNote
+		for i, title := range test.titles {
+			guid := string(rune('a' + i))
+			post := &Post{GUID: guid, Title: title, Date: test.dates[i], Published: true, Extension: ".md"}
+			post.Slug = slug.slug(title, "", guid, post)
+			notes = append(notes, &cachedNote{guid: guid, post: post})
+		}
+		if err := checkCollisions(target, notes); (err != nil) != test.wantErr {
+			t.Errorf("%v: got error %v", test.name, err)
+		}
+	}
+}
@@ -23,12 +23,14 @@ func main() {
 		},
 	}
 
+	var dryRun bool
+
 	var cmdSync = &cobra.Command{
 		Use:   "sync",
 		Short: "Sync local cache and evernote notes",
 		Run: func(cmd *cobra.Command, args []string) {
 			cfg := loadConfig()
-			if err := sync.Sync(cacheRoot, noteCacheDirName, resourceCacheDirName, cfg.ClientKey, cfg.ClientSecret, cfg.DeveloperToken, cfg.Sandbox, cfg.NotebookName); err != nil {
+			if err := sync.Sync(cacheRoot, noteCacheDirName, resourceCacheDirName, cfg.ClientKey, cfg.ClientSecret, cfg.DeveloperToken, cfg.Sandbox, cfg.NotebookName, dryRun); err != nil {
 				fmt.Printf("%+v\n", err)
 				os.Exit(-1)
 			}
@@ -39,16 +41,42 @@ func main() {
 		Use:   "convert",
 		Short: "Convert local cache to post files",
 		Run: func(cmd *cobra.Command, args []string) {
-			loadConfig()
-			if err := convert.Convert(cacheRoot, noteCacheDirName, resourceCacheDirName, ".", postDirName, resourceDirName, true); err != nil {
+			cfg := loadConfig()
+			if err := convert.Convert(cacheRoot, noteCacheDirName, resourceCacheDirName, ".", cfg.NotebookName, cfg.Convert, dryRun); err != nil {
+				fmt.Printf("%+v\n", err)
+				os.Exit(-1)
+			}
+		},
+	}
+
+	var style string
+
+	var cmdStylesheet = &cobra.Command{
+		Use:   "stylesheet",
+		Short: "Print css for code blocks highlighted with classes",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := getConvertOptions()
+			if err != nil {
+				fmt.Printf("%+v\n", err)
+				os.Exit(-1)
+			}
+			if style != "" {
+				opts.Highlight.Style = style
+			}
+			if err := convert.WriteStylesheet(os.Stdout, *opts); err != nil {
 				fmt.Printf("%+v\n", err)
 				os.Exit(-1)
 			}
 		},
 	}
 
-	var rootCmd = &cobra.Command{Use: "chienote", Long: "Sync your evernote notebook to your jekyll directory. Execute chienote at your jekyll root."}
-	rootCmd.AddCommand(cmdInit, cmdSync, cmdConvert)
+	cmdSync.Flags().BoolVar(&dryRun, "dry-run", false, "Show notes and resources to be downloaded or deleted without writing the cache")
+	cmdConvert.Flags().BoolVar(&dryRun, "dry-run", false, "Show files to be created, overwritten or removed without touching the site directory")
+
+	cmdStylesheet.Flags().StringVar(&style, "style", "", "Chroma style of the stylesheet instead of the one in the configuration file")
+
+	var rootCmd = &cobra.Command{Use: "chienote", Long: "Sync your evernote notebook to your jekyll or hugo directory. Execute chienote at your site root."}
+	rootCmd.AddCommand(cmdInit, cmdSync, cmdConvert, cmdStylesheet)
 	rootCmd.Execute()
 }
 
@@ -22,17 +22,20 @@ const minimumFetchIntervalSeconds = 15 * 60
 const cacheExtension = ".yml"
 
 // Sync local cache from evernote server
-func Sync(cacheRoot string, noteCacheDirName string, resourceCacheDirName string, clientKey string, clientSecret string, developerToken string, isSandbox bool, notebookName string) error {
+// If dryRun is true, it only prints what would be downloaded or deleted and leaves the cache untouched.
+func Sync(cacheRoot string, noteCacheDirName string, resourceCacheDirName string, clientKey string, clientSecret string, developerToken string, isSandbox bool, notebookName string, dryRun bool) error {
 	cacheRoot = path.Clean(cacheRoot)
 	noteCacheDir := path.Join(cacheRoot, noteCacheDirName)
 	resourceCacheDir := path.Join(cacheRoot, resourceCacheDirName)
 
-	if err := os.MkdirAll(noteCacheDir, os.ModePerm); err != nil {
-		return errors.Wrapf(err, "couldn't create note cache path %v", noteCacheDir)
-	}
+	if !dryRun {
+		if err := os.MkdirAll(noteCacheDir, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "couldn't create note cache path %v", noteCacheDir)
+		}
 
-	if err := os.MkdirAll(resourceCacheDir, os.ModePerm); err != nil {
-		return errors.Wrapf(err, "couldn't create resource cache path %v", resourceCacheDir)
+		if err := os.MkdirAll(resourceCacheDir, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "couldn't create resource cache path %v", resourceCacheDir)
+		}
 	}
 
 	cli := client.NewClient(clientKey, clientSecret, getEnvironment(isSandbox))
@@ -47,7 +50,7 @@ func Sync(cacheRoot string, noteCacheDirName string, resourceCacheDirName string
 		return err
 	}
 
-	notUpdated, err := checkUpdate(ns, &cacheRoot, &developerToken)
+	notUpdated, err := checkUpdate(ns, &cacheRoot, &developerToken, dryRun)
 	if err != nil {
 		return err
 	}
@@ -80,6 +83,14 @@ func Sync(cacheRoot string, noteCacheDirName string, resourceCacheDirName string
 		}
 
 		if cachedNote == nil || cachedNote.UpdateSequenceNum == nil || note.UpdateSequenceNum == nil || *cachedNote.UpdateSequenceNum != *note.UpdateSequenceNum {
+			if dryRun {
+				if err := reportNoteDownload(ns, &developerToken, cachedNote, note.GUID); err != nil {
+					return err
+				}
+				cachedIds.Remove(note.GUID)
+				continue
+			}
+
 			note, err := ns.GetNote(developerToken, note.GUID, true, false, false, false)
 			if err != nil {
 				return errors.Wrapf(err, "can't get note %v", *note.GUID)
@@ -102,7 +113,12 @@ func Sync(cacheRoot string, noteCacheDirName string, resourceCacheDirName string
 
 	for _, id := range cachedIds.ToSlice() {
 		guid := id.(string)
-		os.Remove(noteCacheDir + guid + cacheExtension)
+		deletedCachePath := path.Join(noteCacheDir, guid+cacheExtension)
+		if dryRun {
+			fmt.Printf("[dry-run] would delete %v\n", deletedCachePath)
+			continue
+		}
+		os.Remove(deletedCachePath)
 	}
 
 	return nil
@@ -186,6 +202,9 @@ func findMetadatas(ns *notestore.NoteStoreClient, bookGUID *types.GUID, develope
 func createCachedNoteIDMap(noteCachePath *string) (mapset.Set, error) {
 	cachedIDs := mapset.NewSet()
 	cacheFileInfos, err := ioutil.ReadDir(*noteCachePath)
+	if os.IsNotExist(err) {
+		return cachedIDs, nil
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't read cache directory %v", *noteCachePath)
 	}
@@ -245,6 +264,54 @@ func writeCachedNoteToFile(cachePath *string, note *types.Note) error {
 }
 
 func saveResources(resourceCacheDir *string, cachedNote *types.Note, receivedNote *types.Note, ns *notestore.NoteStoreClient, developerToken *string) error {
+	fetchingNeeded := resourcesToFetch(cachedNote, receivedNote)
+
+	for _, resource := range receivedNote.Resources {
+		if !fetchingNeeded[*resource.GUID] {
+			fmt.Printf("not updated %v\n", *resource.GUID)
+			continue
+		}
+
+		resourceWithBytes, err := ns.GetResource(*developerToken, *resource.GUID, true, false, true, false)
+		if err != nil {
+			return errors.Wrapf(err, "can't get resource %v", *resource.GUID)
+		}
+
+		var resourceFileName string
+		if resourceWithBytes.Attributes.FileName == nil {
+			// https://dev.evernote.com/doc/articles/resources.php#downloading
+			var extension string
+			switch *resourceWithBytes.Mime {
+			case "image/gif":
+				extension = ".gif"
+			case "image/jpeg":
+				extension = ".jpg"
+			case "image/png":
+				extension = ".png"
+			case "audio/wav":
+				extension = ".wav"
+			case "audio/mpeg":
+				extension = ".mp3"
+			case "audio/amr":
+				extension = ".amr"
+			case "audio/pdf":
+				extension = ".pdf"
+			}
+			resourceFileName = hex.EncodeToString(resourceWithBytes.Data.BodyHash) + extension
+		} else {
+			resourceFileName = hex.EncodeToString(resourceWithBytes.Data.BodyHash) + "-" + *resourceWithBytes.Attributes.FileName
+		}
+
+		p := path.Join(*resourceCacheDir, resourceFileName)
+		ioutil.WriteFile(p, resourceWithBytes.Data.Body, os.ModePerm)
+		fmt.Println("write resource to " + p)
+	}
+
+	return nil
+}
+
+// resourcesToFetch returns resource GUIDs of receivedNote which are new or updated since cachedNote
+func resourcesToFetch(cachedNote *types.Note, receivedNote *types.Note) map[types.GUID]bool {
 	localResourceMap := map[types.GUID]int32{}
 	if cachedNote != nil {
 		for _, cachedResource := range cachedNote.Resources {
@@ -252,62 +319,35 @@ func saveResources(resourceCacheDir *string, cachedNote *types.Note, receivedNot
 		}
 	}
 
+	fetchingNeeded := map[types.GUID]bool{}
 	for _, resource := range receivedNote.Resources {
-		fetchingNeeded := false
 		cachedUpdateNum, exists := localResourceMap[*resource.GUID]
-		if exists {
+		fetchingNeeded[*resource.GUID] = !exists || cachedUpdateNum != *resource.UpdateSequenceNum
+	}
 
-			if cachedUpdateNum != *resource.UpdateSequenceNum {
-				fetchingNeeded = true
-			}
-			delete(localResourceMap, *resource.GUID)
-		} else {
-			fetchingNeeded = true
-		}
+	return fetchingNeeded
+}
 
-		if fetchingNeeded {
-			resourceWithBytes, err := ns.GetResource(*developerToken, *resource.GUID, true, false, true, false)
-			if err != nil {
-				return errors.Wrapf(err, "can't get resource %v", *resource.GUID)
-			}
+// reportNoteDownload prints the note and resources which would be downloaded, without fetching their contents
+func reportNoteDownload(ns *notestore.NoteStoreClient, developerToken *string, cachedNote *types.Note, noteGUID types.GUID) error {
+	note, err := ns.GetNote(*developerToken, noteGUID, false, false, false, false)
+	if err != nil {
+		return errors.Wrapf(err, "can't get note %v", noteGUID)
+	}
 
-			var resourceFileName string
-			if resourceWithBytes.Attributes.FileName == nil {
-				// https://dev.evernote.com/doc/articles/resources.php#downloading
-				var extension string
-				switch *resourceWithBytes.Mime {
-				case "image/gif":
-					extension = ".gif"
-				case "image/jpeg":
-					extension = ".jpg"
-				case "image/png":
-					extension = ".png"
-				case "audio/wav":
-					extension = ".wav"
-				case "audio/mpeg":
-					extension = ".mp3"
-				case "audio/amr":
-					extension = ".amr"
-				case "audio/pdf":
-					extension = ".pdf"
-				}
-				resourceFileName = hex.EncodeToString(resourceWithBytes.Data.BodyHash) + extension
-			} else {
-				resourceFileName = hex.EncodeToString(resourceWithBytes.Data.BodyHash) + "-" + *resourceWithBytes.Attributes.FileName
-			}
+	fmt.Printf("[dry-run] would download %v[%v]\n", *note.Title, *note.GUID)
 
-			p := path.Join(*resourceCacheDir, resourceFileName)
-			ioutil.WriteFile(p, resourceWithBytes.Data.Body, os.ModePerm)
-			fmt.Println("write resource to " + p)
-		} else {
-			fmt.Printf("not updated %v\n", *resource.GUID)
+	fetchingNeeded := resourcesToFetch(cachedNote, note)
+	for _, resource := range note.Resources {
+		if fetchingNeeded[*resource.GUID] {
+			fmt.Printf("[dry-run] would download resource %v\n", *resource.GUID)
 		}
 	}
 
 	return nil
 }
 
-func checkUpdate(ns *notestore.NoteStoreClient, cacheDir *string, developerToken *string) (notUpdated bool, err error) {
+func checkUpdate(ns *notestore.NoteStoreClient, cacheDir *string, developerToken *string, dryRun bool) (notUpdated bool, err error) {
 	syncState, err := ns.GetSyncState(*developerToken)
 	if err != nil {
 		return false, errors.Wrap(err, "can't get sync state")
@@ -329,6 +369,10 @@ func checkUpdate(ns *notestore.NoteStoreClient, cacheDir *string, developerToken
 		}
 	}
 
+	if dryRun {
+		return false, nil
+	}
+
 	stateBytes, err := yaml.Marshal(syncState)
 	if err != nil {
 		return false, errors.Wrapf(err, "can't marshal sync state")